@@ -1,4 +1,63 @@
 package config
 
+import (
+	"time"
+)
+
+// 引擎配置, 按值传递, 单次查询可以复制后通过 Set 覆盖
 type SQLConf struct {
+	QueryTimeout     time.Duration  // 查询超时时间, 0 表示不限制
+	UnionParallelism int            // union 同时执行的子查询数量
+	IgnoreCase       bool           // 字段名、别名不区分大小写, 统一转为小写, 默认区分
+	TimeZone         *time.Location // from_unixtime 等格式化时间使用的时区
+	MemoryLimit      int64          // 单个查询缓存数据的内存上限, 单位字节, 0 表示不限制
+	OutputFormat     string         // 默认的结果输出格式
+	HadoopBin        string         // hadoop 命令路径
+	AllowedSources   []string       // 允许使用的数据源, 为空时不限制
+	RecursionDepth   int            // 递归 with 子句的最大迭代次数
+}
+
+// 默认配置
+func Default() SQLConf {
+	return SQLConf{
+		UnionParallelism: 5,
+		TimeZone:         time.Local,
+		OutputFormat:     "table",
+		HadoopBin:        "hadoop",
+		RecursionDepth:   100,
+	}
+}
+
+// 数据源是否在允许列表中
+func (c SQLConf) SourceAllowed(name string) bool {
+	if len(c.AllowedSources) == 0 {
+		return true
+	}
+	for _, s := range c.AllowedSources {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 零值配置中未设置的字段使用默认值, 兼容直接使用 SQLConf{} 的调用方
+func (c SQLConf) WithDefaults() SQLConf {
+	d := Default()
+	if c.UnionParallelism <= 0 {
+		c.UnionParallelism = d.UnionParallelism
+	}
+	if c.TimeZone == nil {
+		c.TimeZone = d.TimeZone
+	}
+	if c.OutputFormat == "" {
+		c.OutputFormat = d.OutputFormat
+	}
+	if c.HadoopBin == "" {
+		c.HadoopBin = d.HadoopBin
+	}
+	if c.RecursionDepth <= 0 {
+		c.RecursionDepth = d.RecursionDepth
+	}
+	return c
 }
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 环境变量前缀, 如 SQL_ENGINE_UNION_PARALLELISM 对应 union.parallelism
+const EnvPrefix = "SQL_ENGINE_"
+
+// 依次使用默认配置、配置文件和环境变量生成配置, path 为空时不读取配置文件
+func Load(path string) (SQLConf, error) {
+	conf := Default()
+	if path != "" {
+		if err := conf.LoadFile(path); err != nil {
+			return conf, err
+		}
+	}
+	if err := conf.LoadEnv(); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+// 读取配置文件, 每行一个 key = value, # 开头为注释
+func (c *SQLConf) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%s:%d: expect key = value", path, lineNo)
+		}
+		if err = c.Set(strings.TrimSpace(kv[0]), kv[1]); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// 读取 SQL_ENGINE_ 开头的环境变量
+func (c *SQLConf) LoadEnv() error {
+	for _, s := range settings {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.Key, ".", "_"))
+		if value, ok := os.LookupEnv(name); ok {
+			if err := c.Set(s.Key, value); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 一个可以通过名称读写的配置项, 名称用于配置文件、环境变量以及 SET 语句
+type Setting struct {
+	Key      string
+	Doc      string
+	LoadOnly bool // 只能在配置文件、环境变量中设置, 不能通过 SET 语句修改
+	get      func(c *SQLConf) string
+	set      func(c *SQLConf, value string) error
+	limit    func(c *SQLConf) int64 // 不为空时 SET 语句只能降低这个上限, 0 表示不限制
+}
+
+var OutputFormats = []string{"table", "csv", "tsv", "json", "jsonl", "markdown"}
+
+var settings = []Setting{
+	{
+		Key: "query.timeout",
+		Doc: "查询超时时间, 如 30s、5m, 0 表示不限制",
+		get: func(c *SQLConf) string { return c.QueryTimeout.String() },
+		set: func(c *SQLConf, value string) (err error) {
+			c.QueryTimeout, err = parseDuration(value)
+			return
+		},
+		limit: func(c *SQLConf) int64 { return int64(c.QueryTimeout) },
+	},
+	{
+		Key: "union.parallelism",
+		Doc: "union 同时执行的子查询数量",
+		get: func(c *SQLConf) string { return strconv.Itoa(c.UnionParallelism) },
+		set: func(c *SQLConf, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("must be a positive integer")
+			}
+			c.UnionParallelism = n
+			return nil
+		},
+	},
+	{
+		Key: "case.sensitive",
+		Doc: "字段名、别名是否区分大小写",
+		get: func(c *SQLConf) string { return strconv.FormatBool(!c.IgnoreCase) },
+		set: func(c *SQLConf, value string) error {
+			sensitive, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			c.IgnoreCase = !sensitive
+			return nil
+		},
+	},
+	{
+		Key: "timezone",
+		Doc: "from_unixtime 等格式化时间使用的时区, 如 UTC、Asia/Shanghai, Local 表示本机时区",
+		get: func(c *SQLConf) string { return c.TimeZone.String() },
+		set: func(c *SQLConf, value string) (err error) {
+			c.TimeZone, err = time.LoadLocation(value)
+			return
+		},
+	},
+	{
+		Key: "memory.limit",
+		Doc: "单个查询缓存数据的内存上限, 支持 K、M、G 后缀, 0 表示不限制",
+		get: func(c *SQLConf) string { return formatBytes(c.MemoryLimit) },
+		set: func(c *SQLConf, value string) (err error) {
+			c.MemoryLimit, err = parseBytes(value)
+			return
+		},
+		limit: func(c *SQLConf) int64 { return c.MemoryLimit },
+	},
+	{
+		Key: "output.format",
+		Doc: "默认的结果输出格式: " + strings.Join(OutputFormats, ", "),
+		get: func(c *SQLConf) string { return c.OutputFormat },
+		set: func(c *SQLConf, value string) error {
+			value = strings.ToLower(value)
+			for _, f := range OutputFormats {
+				if f == value {
+					c.OutputFormat = value
+					return nil
+				}
+			}
+			return fmt.Errorf("must be one of %s", strings.Join(OutputFormats, ", "))
+		},
+	},
+	{
+		Key:      "hadoop.bin",
+		Doc:      "hadoop 命令路径",
+		LoadOnly: true,
+		get:      func(c *SQLConf) string { return c.HadoopBin },
+		set: func(c *SQLConf, value string) error {
+			if value == "" {
+				return fmt.Errorf("can not be empty")
+			}
+			c.HadoopBin = value
+			return nil
+		},
+	},
+	{
+		Key: "recursion.depth",
+		Doc: "递归 with 子句的最大迭代次数",
+		get: func(c *SQLConf) string { return strconv.Itoa(c.RecursionDepth) },
+		set: func(c *SQLConf, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("must be a positive integer")
+			}
+			c.RecursionDepth = n
+			return nil
+		},
+	},
+	{
+		Key:      "source.allow",
+		Doc:      "允许使用的数据源, 逗号分隔, 如 fs,hdfs, 为空时不限制",
+		LoadOnly: true,
+		get:      func(c *SQLConf) string { return strings.Join(c.AllowedSources, ",") },
+		set: func(c *SQLConf, value string) error {
+			// 重新分配, 避免修改复制前的配置
+			c.AllowedSources = nil
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					c.AllowedSources = append(c.AllowedSources, s)
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// 所有配置项, 按名称排序
+func Settings() []Setting {
+	result := append([]Setting(nil), settings...)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+func lookup(key string) (Setting, error) {
+	for _, s := range settings {
+		if s.Key == strings.ToLower(key) {
+			return s, nil
+		}
+	}
+	return Setting{}, fmt.Errorf("unknown setting: %s", key)
+}
+
+// 按名称设置配置项
+func (c *SQLConf) Set(key, value string) error {
+	s, err := lookup(key)
+	if err != nil {
+		return err
+	}
+	if err = s.set(c, strings.TrimSpace(value)); err != nil {
+		return fmt.Errorf("invalid value '%s' for %s: %v", value, s.Key, err)
+	}
+	return nil
+}
+
+// SET 语句修改配置项, 执行的命令、允许的数据源不能修改, 超时时间和内存上限只能降低
+func (c *SQLConf) SetInSession(key, value string) error {
+	s, err := lookup(key)
+	if err != nil {
+		return err
+	}
+	if s.LoadOnly {
+		return fmt.Errorf("%s can only be set in config file or environment variables", s.Key)
+	}
+	next := *c
+	if err = next.Set(key, value); err != nil {
+		return err
+	}
+	if s.limit != nil {
+		if current, value := s.limit(c), s.limit(&next); current > 0 && (value <= 0 || value > current) {
+			return fmt.Errorf("%s can only be lowered in session, current value is %s", s.Key, s.get(c))
+		}
+	}
+	*c = next
+	return nil
+}
+
+// 按名称读取配置项
+func (c SQLConf) Get(key string) (string, error) {
+	s, err := lookup(key)
+	if err != nil {
+		return "", err
+	}
+	c = c.WithDefaults()
+	return s.get(&c), nil
+}
+
+// 复制配置并覆盖部分配置项, 用于单次查询
+func (c SQLConf) With(overrides map[string]string) (SQLConf, error) {
+	for key, value := range overrides {
+		if err := c.Set(key, value); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// 纯数字视为秒
+func parseDuration(value string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+func parseBytes(value string) (int64, error) {
+	value = strings.TrimSuffix(strings.ToUpper(value), "B")
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			unit = u.size
+			value = strings.TrimSuffix(value, u.suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("must be a non-negative size")
+	}
+	return n * unit, nil
+}
+
+func formatBytes(n int64) string {
+	for _, u := range byteUnits {
+		if n != 0 && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
@@ -66,6 +66,26 @@ func (in *In) Eval(row rows.Row) interface{} {
 	return pointer.Bool(false)
 }
 
+func (in *InSubquery) Eval(_ rows.Row) interface{} {
+	panic("subquery should be rewritten to join")
+}
+
+func (e *Exists) Eval(_ rows.Row) interface{} {
+	panic("subquery should be rewritten to join")
+}
+
+func (s *ScalarSubquery) Eval(_ rows.Row) interface{} {
+	if !s.executed {
+		panic("scalar subquery is not executed")
+	}
+	return s.Result
+}
+
+func (s *ScalarSubquery) SetResult(value interface{}) {
+	s.Result = value
+	s.executed = true
+}
+
 func (lt *LessThan) Eval(row rows.Row) interface{} {
 	return upcastingCompare(lt.Left.Eval(row), lt.Right.Eval(row), func(v1 *string, v2 *string) *bool {
 		return pointer.Bool(*v1 < *v2)
@@ -152,21 +172,7 @@ func (i *If) Eval(row rows.Row) interface{} {
 }
 
 func (cast *Cast) Eval(row rows.Row) interface{} {
-	r := cast.Child.Eval(row)
-	if pointer.IsNil(r) {
-		return nullByType(cast.DataType)
-	}
-	switch cast.DataType {
-	case rows.Int:
-		return castAsInt(r)
-	case rows.Float:
-		return castAsFloat(r)
-	case rows.String:
-		return castAsString(r)
-	case rows.Boolean:
-		return castAsBool(r)
-	}
-	return nil
+	return castAs(cast.Child.Eval(row), cast.DataType)
 }
 
 func (c *CaseWhen) Eval(row rows.Row) interface{} {
@@ -249,7 +255,7 @@ func (n *Not) Eval(row rows.Row) interface{} {
 	return pointer.Bool(!*value)
 }
 
-func nullByType(t rows.DataType) interface{} {
+func NullByType(t rows.DataType) interface{} {
 	switch t {
 	case rows.Boolean:
 		return (*bool)(nil)
@@ -263,6 +269,23 @@ func nullByType(t rows.DataType) interface{} {
 	return nil
 }
 
+func castAs(v interface{}, t rows.DataType) interface{} {
+	if pointer.IsNil(v) {
+		return NullByType(t)
+	}
+	switch t {
+	case rows.Int:
+		return castAsInt(v)
+	case rows.Float:
+		return castAsFloat(v)
+	case rows.String:
+		return castAsString(v)
+	case rows.Boolean:
+		return castAsBool(v)
+	}
+	return nil
+}
+
 func castAsInt(e interface{}) *int64 {
 	if v, ok := e.(*int64); ok {
 		return v
@@ -1,6 +1,7 @@
 package expression
 
 import (
+	"reflect"
 	"sql-engine/rows"
 )
 
@@ -148,8 +149,31 @@ type (
 	Not struct {
 		Child Expression
 	}
+
+	// value in (select ...), 由分析器改写为 semi join
+	InSubquery struct {
+		Value Expression
+		Query Query
+	}
+
+	// exists (select ...), 由分析器改写为 semi join
+	Exists struct {
+		Query Query
+	}
+
+	// 标量子查询, 由 plan 在执行前计算一次后写入 Result
+	ScalarSubquery struct {
+		Query    Query
+		Result   interface{}
+		executed bool // 结果可能为 nil, 需要单独记录是否已执行
+	}
 )
 
+// 子查询, 实际类型为 plan.Plan, 为避免循环引用这里只依赖 GetSchema
+type Query interface {
+	GetSchema() []rows.StructField
+}
+
 func (l *Like) GetChildren() []*Expression {
 	return []*Expression{&l.Left, &l.Right}
 }
@@ -201,10 +225,27 @@ func (in *In) GetChildren() []*Expression {
 	return result
 }
 
+func (in *InSubquery) GetChildren() []*Expression {
+	return []*Expression{&in.Value}
+}
+
+func (e *Exists) GetChildren() []*Expression {
+	return []*Expression{}
+}
+
+func (s *ScalarSubquery) GetChildren() []*Expression {
+	return []*Expression{}
+}
+
 func (n *Not) GetChildren() []*Expression {
 	return []*Expression{&n.Child}
 }
 
+// 经过 GetSchema 编译后字段在输入 row 中的下标
+func (a *Attribute) Index() int {
+	return a.idx
+}
+
 func Transform(expr Expression, fn func(expr Expression) Expression) Expression {
 	children := expr.GetChildren()
 	for _, child := range children {
@@ -213,29 +254,44 @@ func Transform(expr Expression, fn func(expr Expression) Expression) Expression
 	return fn(expr)
 }
 
+// 深度复制表达式, 同一个表达式需要在多处分别解析时使用
+func Copy(expr Expression) Expression {
+	value := reflect.ValueOf(expr)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return expr
+	}
+	copied := reflect.New(value.Elem().Type())
+	copied.Elem().Set(value.Elem())
+	// slice 重新分配, 避免与原表达式共用子表达式
+	if copied.Elem().Kind() == reflect.Struct {
+		for i := 0; i < copied.Elem().NumField(); i++ {
+			field := copied.Elem().Field(i)
+			if field.Kind() == reflect.Slice && field.CanSet() && !field.IsNil() {
+				slice := reflect.MakeSlice(field.Type(), field.Len(), field.Len())
+				reflect.Copy(slice, field)
+				field.Set(slice)
+			}
+		}
+	}
+	result := copied.Interface().(Expression)
+	for _, child := range result.GetChildren() {
+		*child = Copy(*child)
+	}
+	return result
+}
+
 type ExprProxy struct {
 	Expr        Expression
-	RowGroup    []rows.Row
 	GroupSchema []rows.StructField
 	idx         int // 编译后生成，如果为 -1 表示该 expr 不是 group by 后的表达式，否则表示 group by 的下标
 }
 
 func (e *ExprProxy) Eval(row rows.Row) interface{} {
 	// 如果被代理对象是 group 的 key, 则从 row 中直接取
-	// 如果被代理对象是聚合类, 则将 RowGroup 传入计算
-	// 否则让被代理对象自行求值
+	// 否则让被代理对象自行求值, 聚合函数返回预先设置好的分组结果
 	if e.idx != -1 {
 		return row.IndexOf(e.idx)
 	}
-	if agg, ok := e.Expr.(AggFunction); ok {
-		agg.SetGroupData(e.RowGroup)
-	}
-	// 被代理类的子类还是一个代理, 将 group 数据继续传递
-	for _, expr := range e.Expr.GetChildren() {
-		if proxy, ok := (*expr).(*ExprProxy); ok {
-			proxy.RowGroup = e.RowGroup
-		}
-	}
 	return e.Expr.Eval(row)
 }
 
@@ -7,6 +7,7 @@ import (
 	"sql-engine/rows"
 	"sql-engine/util/pointer"
 	"strings"
+	"time"
 )
 
 var FuncMap = map[string]Function{
@@ -18,54 +19,119 @@ var FuncMap = map[string]Function{
 	"length":         &Length{},
 	"substr":         &SubStr{},
 	"regexp_extract": &RegexpExtract{},
+	"from_unixtime":  &FromUnixTime{},
+	"row_number":     &RowNumber{},
+	"rank":           &Rank{},
+	"dense_rank":     &DenseRank{},
+	"lag":            &Lag{},
+	"lead":           &Lead{},
+	"first_value":    &FirstValue{},
 }
 
+// 复制 FuncMap 中的函数原型并设置参数
 func NewFuncByName(name string, args []Expression) Expression {
-	ptrType := reflect.TypeOf(FuncMap[name])
-	ptrValue := reflect.New(ptrType.Elem())
+	prototype := reflect.ValueOf(FuncMap[strings.ToLower(name)])
+	ptrValue := reflect.New(prototype.Type().Elem())
+	ptrValue.Elem().Set(prototype.Elem())
 	ptrValue.Elem().FieldByName("Args").Set(reflect.ValueOf(args))
 	return ptrValue.Interface().(Expression)
 }
 
 type Function Expression
 
+// 聚合函数, 每个分组使用各自的 Accumulator 逐行计算, 结果通过 SetResult 设置后由 Eval 返回
 type AggFunction interface {
 	Expression
-	SetGroupData(group []rows.Row)
+	NewAccumulator() Accumulator
+	SetResult(result interface{})
+	SetDistinct(distinct bool)
+}
+
+// 一个分组的聚合状态, 由 AggFunction.NewAccumulator 初始化, Update 逐行更新,
+// Merge 合并其他分区中同一分组的状态, Result 返回最终结果.
+// 状态中不引用其他分组的数据, 可以单独保存和恢复
+type Accumulator interface {
+	Update(row rows.Row)
+	Merge(other Accumulator)
+	Result() interface{}
 }
 
 type baseAgg struct {
-	RowGroup []rows.Row
+	Distinct bool // 如 count(distinct x), 对参数值去重后再聚合
+	result   interface{}
 }
 
-func (b *baseAgg) SetGroupData(group []rows.Row) {
-	b.RowGroup = group
+func (b *baseAgg) Eval(_ rows.Row) interface{} {
+	return b.result
 }
 
-type Count struct {
-	baseAgg
-	Args []Expression
+func (b *baseAgg) SetResult(result interface{}) {
+	b.result = result
 }
 
-func (c *Count) Eval(_ rows.Row) interface{} {
-	arg := c.Args[0]
-	if l, ok := arg.(*Literal); ok {
-		if l.IsNull {
-			return pointer.Int64(0)
-		}
-		return pointer.Int64(int64(len(c.RowGroup)))
+func (b *baseAgg) SetDistinct(distinct bool) {
+	b.Distinct = distinct
+}
+
+// distinct 时参数值相同的行只计算一次
+func (b *baseAgg) wrapDistinct(arg Expression, newAcc func() Accumulator) Accumulator {
+	if !b.Distinct {
+		return newAcc()
+	}
+	return &distinctAccumulator{arg: arg, newAcc: newAcc, values: make(map[string]rows.Row)}
+}
+
+func (b *baseAgg) printDistinct() string {
+	if b.Distinct {
+		return "distinct "
+	}
+	return ""
+}
+
+// 每个不同的参数值保留一行, 合并后才能得到正确的去重结果, 因此在 Result 时才计算
+type distinctAccumulator struct {
+	arg    Expression
+	newAcc func() Accumulator
+	values map[string]rows.Row
+}
+
+func (d *distinctAccumulator) Update(row rows.Row) {
+	// 字符串带有引号，不会与其他类型冲突
+	key := pointer.PointerContent(d.arg.Eval(row))
+	if _, ok := d.values[key]; !ok {
+		d.values[key] = row
 	}
-	var count int64 = 0
-	for _, row := range c.baseAgg.RowGroup {
-		if !pointer.IsNil(arg.Eval(row)) {
-			count += 1
+}
+
+func (d *distinctAccumulator) Merge(other Accumulator) {
+	for key, row := range other.(*distinctAccumulator).values {
+		if _, ok := d.values[key]; !ok {
+			d.values[key] = row
 		}
 	}
-	return pointer.Int64(count)
+}
+
+func (d *distinctAccumulator) Result() interface{} {
+	acc := d.newAcc()
+	for _, row := range d.values {
+		acc.Update(row)
+	}
+	return acc.Result()
+}
+
+type Count struct {
+	baseAgg
+	Args []Expression
+}
+
+func (c *Count) NewAccumulator() Accumulator {
+	return c.wrapDistinct(c.Args[0], func() Accumulator {
+		return &countAccumulator{arg: c.Args[0]}
+	})
 }
 
 func (c *Count) Print() (s string) {
-	return fmt.Sprintf("count(%s)", c.Args[0].Print())
+	return fmt.Sprintf("count(%s%s)", c.printDistinct(), c.Args[0].Print())
 }
 
 func (c *Count) GetSchema(option []rows.StructField) rows.StructField {
@@ -80,6 +146,25 @@ func (c *Count) GetChildren() []*Expression {
 	return []*Expression{&c.Args[0]}
 }
 
+type countAccumulator struct {
+	arg   Expression
+	count int64
+}
+
+func (c *countAccumulator) Update(row rows.Row) {
+	if !pointer.IsNil(c.arg.Eval(row)) {
+		c.count += 1
+	}
+}
+
+func (c *countAccumulator) Merge(other Accumulator) {
+	c.count += other.(*countAccumulator).count
+}
+
+func (c *countAccumulator) Result() interface{} {
+	return pointer.Int64(c.count)
+}
+
 type Concat struct {
 	Args []Expression
 }
@@ -126,40 +211,16 @@ type Min struct {
 	Args []Expression
 }
 
-func (m *Min) Eval(_ rows.Row) interface{} {
-	var minValue interface{}
-	for _, r := range m.RowGroup {
-		newValue := m.Args[0].Eval(r)
-		// 记录的值为 nil
-		if pointer.IsNil(minValue) {
-			minValue = newValue
-			continue
-		}
-		if !pointer.IsNil(newValue) {
-			if v1, v2, ok := pointer.BothString(newValue, minValue); ok {
-				if *v1 < *v2 {
-					minValue = newValue
-				}
-			} else if v1, v2, ok := pointer.BothInt64(newValue, minValue); ok {
-				if *v1 < *v2 {
-					minValue = newValue
-				}
-			} else if v1, v2, ok := pointer.BothFloat64(newValue, minValue); ok {
-				if *v1 < *v2 {
-					minValue = newValue
-				}
-			} else if v1, v2, ok := pointer.BothBool(newValue, minValue); ok {
-				if !*v1 && *v2 {
-					minValue = newValue
-				}
-			}
-		}
-	}
-	return minValue
+func (m *Min) NewAccumulator() Accumulator {
+	return m.wrapDistinct(m.Args[0], func() Accumulator {
+		return &compareAccumulator{arg: m.Args[0], replace: func(newValue, value interface{}) bool {
+			return lessThan(newValue, value)
+		}}
+	})
 }
 
 func (m *Min) Print() (s string) {
-	return fmt.Sprintf("min(%s)", m.Args[0].Print())
+	return fmt.Sprintf("min(%s%s)", m.printDistinct(), m.Args[0].Print())
 }
 
 func (m *Min) GetSchema(option []rows.StructField) rows.StructField {
@@ -179,40 +240,16 @@ type Max struct {
 	Args []Expression
 }
 
-func (m *Max) Eval(_ rows.Row) interface{} {
-	var maxValue interface{}
-	for _, r := range m.RowGroup {
-		newValue := m.Args[0].Eval(r)
-		// 记录的值为 nil
-		if pointer.IsNil(maxValue) {
-			maxValue = newValue
-			continue
-		}
-		if !pointer.IsNil(newValue) {
-			if v1, v2, ok := pointer.BothString(newValue, maxValue); ok {
-				if *v1 > *v2 {
-					maxValue = newValue
-				}
-			} else if v1, v2, ok := pointer.BothInt64(newValue, maxValue); ok {
-				if *v1 > *v2 {
-					maxValue = newValue
-				}
-			} else if v1, v2, ok := pointer.BothFloat64(newValue, maxValue); ok {
-				if *v1 > *v2 {
-					maxValue = newValue
-				}
-			} else if v1, v2, ok := pointer.BothBool(newValue, maxValue); ok {
-				if *v1 && !*v2 {
-					maxValue = newValue
-				}
-			}
-		}
-	}
-	return maxValue
+func (m *Max) NewAccumulator() Accumulator {
+	return m.wrapDistinct(m.Args[0], func() Accumulator {
+		return &compareAccumulator{arg: m.Args[0], replace: func(newValue, value interface{}) bool {
+			return lessThan(value, newValue)
+		}}
+	})
 }
 
 func (m *Max) Print() (s string) {
-	return fmt.Sprintf("max(%s)", m.Args[0].Print())
+	return fmt.Sprintf("max(%s%s)", m.printDistinct(), m.Args[0].Print())
 }
 
 func (m *Max) GetSchema(option []rows.StructField) rows.StructField {
@@ -227,42 +264,63 @@ func (m *Max) GetChildren() []*Expression {
 	return []*Expression{&m.Args[0]}
 }
 
-type Sum struct {
-	baseAgg
-	Args []Expression
+// 用于 min 和 max, replace 返回 true 时使用新值替换记录的值
+type compareAccumulator struct {
+	arg     Expression
+	value   interface{}
+	replace func(newValue, value interface{}) bool
 }
 
-func (s *Sum) Eval(_ rows.Row) interface{} {
-	var sum float64 = 0
-	var dataType *rows.DataType
-	for i, row := range s.RowGroup {
-		r := s.Args[0].Eval(row)
-		// 第一条计算下数据类型
-		if i == 0 {
-			if _, ok := r.(*int64); ok {
-				t := rows.Int
-				dataType = &t
-			} else {
-				t := rows.Float
-				dataType = &t
-			}
-		}
-		f := castAsFloat(r)
-		if f != nil {
-			sum += *f
-		}
+func (c *compareAccumulator) Update(row rows.Row) {
+	c.offer(c.arg.Eval(row))
+}
+
+func (c *compareAccumulator) Merge(other Accumulator) {
+	c.offer(other.(*compareAccumulator).value)
+}
+
+func (c *compareAccumulator) offer(newValue interface{}) {
+	// 记录的值为 nil
+	if pointer.IsNil(c.value) {
+		c.value = newValue
+		return
 	}
-	if dataType == nil {
-		return nil
+	if !pointer.IsNil(newValue) && c.replace(newValue, c.value) {
+		c.value = newValue
 	}
-	if *dataType == rows.Int {
-		return pointer.Int64(int64(sum))
+}
+
+func (c *compareAccumulator) Result() interface{} {
+	return c.value
+}
+
+// 比较两个相同类型的非空值
+func lessThan(v1, v2 interface{}) bool {
+	if s1, s2, ok := pointer.BothString(v1, v2); ok {
+		return *s1 < *s2
+	} else if i1, i2, ok := pointer.BothInt64(v1, v2); ok {
+		return *i1 < *i2
+	} else if f1, f2, ok := pointer.BothFloat64(v1, v2); ok {
+		return *f1 < *f2
+	} else if b1, b2, ok := pointer.BothBool(v1, v2); ok {
+		return !*b1 && *b2
 	}
-	return pointer.Float64(sum)
+	return false
+}
+
+type Sum struct {
+	baseAgg
+	Args []Expression
+}
+
+func (s *Sum) NewAccumulator() Accumulator {
+	return s.wrapDistinct(s.Args[0], func() Accumulator {
+		return &sumAccumulator{arg: s.Args[0]}
+	})
 }
 
 func (s *Sum) Print() string {
-	return fmt.Sprintf("Sum(%s)", s.Args[0].Print())
+	return fmt.Sprintf("Sum(%s%s)", s.printDistinct(), s.Args[0].Print())
 }
 
 func (s *Sum) GetSchema(option []rows.StructField) rows.StructField {
@@ -282,6 +340,47 @@ func (s *Sum) GetChildren() []*Expression {
 	return []*Expression{&s.Args[0]}
 }
 
+type sumAccumulator struct {
+	arg      Expression
+	sum      float64
+	dataType *rows.DataType // 由第一条数据决定
+}
+
+func (s *sumAccumulator) Update(row rows.Row) {
+	r := s.arg.Eval(row)
+	if s.dataType == nil {
+		t := rows.Float
+		if _, ok := r.(*int64); ok {
+			t = rows.Int
+		}
+		s.dataType = &t
+	}
+	if f := castAsFloat(r); f != nil {
+		s.sum += *f
+	}
+}
+
+func (s *sumAccumulator) Merge(other Accumulator) {
+	o := other.(*sumAccumulator)
+	if o.dataType == nil {
+		return
+	}
+	if s.dataType == nil {
+		s.dataType = o.dataType
+	}
+	s.sum += o.sum
+}
+
+func (s *sumAccumulator) Result() interface{} {
+	if s.dataType == nil {
+		return nil
+	}
+	if *s.dataType == rows.Int {
+		return pointer.Int64(int64(s.sum))
+	}
+	return pointer.Float64(s.sum)
+}
+
 type Length struct {
 	Args []Expression
 }
@@ -420,3 +519,61 @@ func (r *RegexpExtract) GetChildren() []*Expression {
 	}
 	return res
 }
+
+// 格式中的日期字段与 go 时间格式的对应关系
+var timeFormatReplacer = strings.NewReplacer("yyyy", "2006", "MM", "01", "dd", "02", "HH", "15", "mm", "04", "ss", "05")
+
+// from_unixtime(seconds[, format]), 把秒级时间戳按 timezone 配置的时区格式化, 如 modify_time,
+// format 支持 yyyy、MM、dd、HH、mm、ss, 默认为 yyyy-MM-dd HH:mm:ss
+type FromUnixTime struct {
+	Args     []Expression
+	Location *time.Location // 由解析器根据配置设置, 为空时使用本机时区
+}
+
+func (f *FromUnixTime) Eval(row rows.Row) interface{} {
+	seconds := castAsInt(f.Args[0].Eval(row))
+	if seconds == nil {
+		return nil
+	}
+	layout := "2006-01-02 15:04:05"
+	if len(f.Args) == 2 {
+		format := castAsString(f.Args[1].Eval(row))
+		if format == nil {
+			return nil
+		}
+		layout = timeFormatReplacer.Replace(*format)
+	}
+	location := f.Location
+	if location == nil {
+		location = time.Local
+	}
+	return pointer.String(time.Unix(*seconds, 0).In(location).Format(layout))
+}
+
+func (f *FromUnixTime) Print() string {
+	if len(f.Args) == 2 {
+		return fmt.Sprintf("from_unixtime(%s, %s)", f.Args[0].Print(), f.Args[1].Print())
+	}
+	return fmt.Sprintf("from_unixtime(%s)", f.Args[0].Print())
+}
+
+func (f *FromUnixTime) GetSchema(option []rows.StructField) rows.StructField {
+	if len(f.Args) == 0 || len(f.Args) > 2 {
+		panic("from_unixtime need one or two params, from_unixtime(seconds[, format])")
+	}
+	if f.Args[0].GetSchema(option).DataType != rows.Int {
+		panic("data type error, from_unixtime need int seconds")
+	}
+	if len(f.Args) == 2 && f.Args[1].GetSchema(option).DataType != rows.String {
+		panic("data type error, from_unixtime need string format")
+	}
+	return rows.StructField{DataType: rows.String}
+}
+
+func (f *FromUnixTime) GetChildren() []*Expression {
+	var result []*Expression
+	for i := range f.Args {
+		result = append(result, &f.Args[i])
+	}
+	return result
+}
@@ -1,8 +1,12 @@
 package expression
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"reflect"
 	"sql-engine/rows"
+	"strings"
 )
 
 func (b *BinaryExpr) Print() string { return "" }
@@ -34,6 +38,18 @@ func (in *In) Print() string {
 	return s + "])"
 }
 
+func (in *InSubquery) Print() string {
+	return fmt.Sprintf("In(%s, subquery)", in.Value.Print())
+}
+
+func (e *Exists) Print() string {
+	return "exists(subquery)"
+}
+
+func (s *ScalarSubquery) Print() string {
+	return "(subquery)"
+}
+
 func (lt *LessThan) Print() string {
 	return fmt.Sprintf("%s < %s", lt.Left.Print(), lt.Right.Print())
 }
@@ -67,7 +83,7 @@ func (d *Divide) Print() string {
 }
 
 func (r *Remainder) Print() string {
-	return fmt.Sprintf("(%s % %s)", r.Left.Print(), r.Right.Print())
+	return fmt.Sprintf("(%s %% %s)", r.Left.Print(), r.Right.Print())
 }
 
 func (i *If) Print() string {
@@ -115,4 +131,54 @@ func (l *Like) Print() string {
 
 func (n *Not) Print() string {
 	return fmt.Sprintf("not(%s)", n.Child.Print())
-}
\ No newline at end of file
+}
+
+// 表达式的 json 结构
+type Node struct {
+	Type     string  `json:"type"`
+	Text     string  `json:"text"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+func ToNode(e Expression) *Node {
+	if proxy, ok := e.(*ExprProxy); ok {
+		e = proxy.Expr
+	}
+	node := &Node{
+		Type: reflect.TypeOf(e).Elem().Name(),
+		Text: e.Print(),
+	}
+	for _, child := range e.GetChildren() {
+		node.Children = append(node.Children, ToNode(*child))
+	}
+	return node
+}
+
+// 以缩进的树输出表达式, 每层缩进 4 个空格
+func WriteTree(w io.Writer, e Expression) error {
+	var walk func(node *Node, level int) error
+	walk = func(node *Node, level int) error {
+		if _, err := fmt.Fprintf(w, "%s- %s: %s\n", strings.Repeat("    ", level), node.Type, node.Text); err != nil {
+			return err
+		}
+		for _, child := range node.Children {
+			if err := walk(child, level+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(ToNode(e), 0)
+}
+
+func TreeString(e Expression) string {
+	var sb strings.Builder
+	_ = WriteTree(&sb, e)
+	return sb.String()
+}
+
+func WriteJSON(w io.Writer, e Expression) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(ToNode(e))
+}
@@ -27,6 +27,24 @@ func (in *In) GetSchema(_ []rows.StructField) rows.StructField {
 	return rows.StructField{DataType: rows.Boolean}
 }
 
+// 子查询谓词在分析阶段被改写为 join, 剩下的说明出现在了不支持的位置
+func (in *InSubquery) GetSchema(_ []rows.StructField) rows.StructField {
+	panic("'in (subquery)' can only be used in where, combined with 'and'")
+}
+
+func (e *Exists) GetSchema(_ []rows.StructField) rows.StructField {
+	panic("'exists' can only be used in where, combined with 'and'")
+}
+
+// 类型由子查询唯一的字段决定
+func (s *ScalarSubquery) GetSchema(_ []rows.StructField) rows.StructField {
+	schema := s.Query.GetSchema()
+	if len(schema) != 1 {
+		panic("scalar subquery must return exactly one column")
+	}
+	return rows.StructField{DataType: schema[0].DataType}
+}
+
 func (lt *LessThan) GetSchema(_ []rows.StructField) rows.StructField {
 	return rows.StructField{DataType: rows.Boolean}
 }
@@ -0,0 +1,227 @@
+package expression
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sql-engine/rows"
+	"sql-engine/util/pointer"
+	"strings"
+)
+
+var funcNameRegex = regexp.MustCompile("^[a-z_][a-z0-9_]*$")
+
+// 注册自定义标量函数, 函数名不区分大小写, 不能与已有函数重名.
+// fn 的参数为按 argTypes 转换后的 int64, float64, string, bool, null 时为 nil;
+// 返回值会按 returnType 转换, 返回 nil 表示 null.
+// 需要在执行 sql 之前注册, 不能与查询并发调用
+func RegisterFunc(name string, argTypes []rows.DataType, returnType rows.DataType, fn func(args ...interface{}) interface{}) error {
+	name = strings.ToLower(name)
+	if err := checkRegister(name, fn == nil); err != nil {
+		return err
+	}
+	FuncMap[name] = &ScalarUDF{
+		Name:       name,
+		ArgTypes:   argTypes,
+		ReturnType: returnType,
+		Fn:         fn,
+	}
+	return nil
+}
+
+// 通过 RegisterFunc 注册的函数
+type ScalarUDF struct {
+	Name       string
+	ArgTypes   []rows.DataType
+	ReturnType rows.DataType
+	Fn         func(args ...interface{}) interface{}
+	Args       []Expression
+}
+
+func (u *ScalarUDF) Eval(row rows.Row) interface{} {
+	var args []interface{}
+	for i, arg := range u.Args {
+		args = append(args, unwrapValue(castAs(arg.Eval(row), u.ArgTypes[i])))
+	}
+	return castAs(wrapValue(u.Fn(args...)), u.ReturnType)
+}
+
+func (u *ScalarUDF) Print() string {
+	return printCall(u.Name, "", u.Args)
+}
+
+func (u *ScalarUDF) GetSchema(option []rows.StructField) rows.StructField {
+	checkArgs(u.Name, u.Args, u.ArgTypes, option)
+	return rows.StructField{DataType: u.ReturnType}
+}
+
+func (u *ScalarUDF) GetChildren() []*Expression {
+	var result []*Expression
+	for i := range u.Args {
+		result = append(result, &u.Args[i])
+	}
+	return result
+}
+
+// 自定义聚合函数一个分组的状态, 参数和返回值的转换规则与 RegisterFunc 相同
+type AggState interface {
+	Update(args ...interface{})
+	Merge(other AggState) // other 为同一个函数的另一个分区的状态
+	Result() interface{}
+}
+
+// 注册自定义聚合函数, newState 为每个分组创建初始状态. 其他规则与 RegisterFunc 相同
+func RegisterAggFunc(name string, argTypes []rows.DataType, returnType rows.DataType, newState func() AggState) error {
+	name = strings.ToLower(name)
+	if err := checkRegister(name, newState == nil); err != nil {
+		return err
+	}
+	FuncMap[name] = &AggregateUDF{
+		Name:       name,
+		ArgTypes:   argTypes,
+		ReturnType: returnType,
+		NewState:   newState,
+	}
+	return nil
+}
+
+// 通过 RegisterAggFunc 注册的聚合函数
+type AggregateUDF struct {
+	baseAgg
+	Name       string
+	ArgTypes   []rows.DataType
+	ReturnType rows.DataType
+	NewState   func() AggState
+	Args       []Expression
+}
+
+func (u *AggregateUDF) NewAccumulator() Accumulator {
+	newAcc := func() Accumulator {
+		return &udfAccumulator{udf: u, state: u.NewState()}
+	}
+	if len(u.Args) == 0 {
+		return newAcc()
+	}
+	return u.wrapDistinct(u.Args[0], newAcc)
+}
+
+func (u *AggregateUDF) Print() string {
+	return printCall(u.Name, u.printDistinct(), u.Args)
+}
+
+func (u *AggregateUDF) GetSchema(option []rows.StructField) rows.StructField {
+	checkArgs(u.Name, u.Args, u.ArgTypes, option)
+	if u.Distinct && len(u.Args) != 1 {
+		panic(fmt.Sprintf("distinct in %s just support one param", u.Name))
+	}
+	return rows.StructField{DataType: u.ReturnType}
+}
+
+func (u *AggregateUDF) GetChildren() []*Expression {
+	var result []*Expression
+	for i := range u.Args {
+		result = append(result, &u.Args[i])
+	}
+	return result
+}
+
+type udfAccumulator struct {
+	udf   *AggregateUDF
+	state AggState
+}
+
+func (u *udfAccumulator) Update(row rows.Row) {
+	var args []interface{}
+	for i, arg := range u.udf.Args {
+		args = append(args, unwrapValue(castAs(arg.Eval(row), u.udf.ArgTypes[i])))
+	}
+	u.state.Update(args...)
+}
+
+func (u *udfAccumulator) Merge(other Accumulator) {
+	u.state.Merge(other.(*udfAccumulator).state)
+}
+
+func (u *udfAccumulator) Result() interface{} {
+	return castAs(wrapValue(u.state.Result()), u.udf.ReturnType)
+}
+
+func checkRegister(name string, nilBody bool) error {
+	if !funcNameRegex.MatchString(name) {
+		return errors.New("illegal function name: " + name)
+	}
+	if _, ok := FuncMap[name]; ok {
+		return errors.New("function already exists: " + name)
+	}
+	if nilBody {
+		return errors.New("function body is nil: " + name)
+	}
+	return nil
+}
+
+// 检查参数个数和类型, bigint 可以作为 double 参数
+func checkArgs(name string, args []Expression, argTypes []rows.DataType, option []rows.StructField) {
+	if len(args) != len(argTypes) {
+		panic(fmt.Sprintf("%s need %d params, got %d", name, len(argTypes), len(args)))
+	}
+	for i, arg := range args {
+		t := arg.GetSchema(option).DataType
+		if t != argTypes[i] && !(t == rows.Int && argTypes[i] == rows.Float) {
+			panic(fmt.Sprintf("%s param %d need '%s', got '%s'",
+				name, i+1, rows.DataTypeName[argTypes[i]], rows.DataTypeName[t]))
+		}
+	}
+}
+
+func printCall(name string, prefix string, args []Expression) string {
+	sb := strings.Builder{}
+	sb.WriteString(name + "(" + prefix)
+	for i, arg := range args {
+		sb.WriteString(arg.Print())
+		if i != len(args)-1 {
+			sb.WriteString(", ")
+		}
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// 将内部使用的指针转为值, null 转为 nil
+func unwrapValue(v interface{}) interface{} {
+	if pointer.IsNil(v) {
+		return nil
+	}
+	switch actual := v.(type) {
+	case *int64:
+		return *actual
+	case *float64:
+		return *actual
+	case *string:
+		return *actual
+	case *bool:
+		return *actual
+	}
+	return nil
+}
+
+// 将值转为内部使用的指针
+func wrapValue(v interface{}) interface{} {
+	switch actual := v.(type) {
+	case int:
+		return pointer.Int64(int64(actual))
+	case int32:
+		return pointer.Int64(int64(actual))
+	case int64:
+		return pointer.Int64(actual)
+	case float32:
+		return pointer.Float64(float64(actual))
+	case float64:
+		return pointer.Float64(actual)
+	case string:
+		return pointer.String(actual)
+	case bool:
+		return pointer.Bool(actual)
+	}
+	// 已经是指针或者为 nil
+	return v
+}
@@ -0,0 +1,341 @@
+package expression
+
+import (
+	"fmt"
+	"sql-engine/rows"
+	"sql-engine/util/pointer"
+	"strings"
+)
+
+// 窗口帧边界的类型
+type BoundType int
+
+const (
+	UnboundedPreceding BoundType = iota
+	Preceding
+	CurrentRow
+	Following
+	UnboundedFollowing
+)
+
+type FrameBound struct {
+	Type   BoundType
+	Offset int64 // Preceding、Following 时与当前行相差的行数或排序值
+}
+
+// rows between ... and ..., Range 为 true 时按排序值确定边界, 否则按行数
+type WindowFrame struct {
+	Range bool
+	Start FrameBound
+	End   FrameBound
+}
+
+// over 中的排序
+type WindowOrder struct {
+	Expr    Expression
+	Reverse bool
+}
+
+// 窗口函数, 如 rank() over (partition by a order by b), 由 plan.Window 按分区计算
+type WindowExpr struct {
+	Function    Expression // WindowFunction 或聚合函数
+	PartitionBy []Expression
+	OrderBy     []WindowOrder
+	Frame       *WindowFrame // 为空时使用默认的帧
+}
+
+func (w *WindowExpr) Eval(_ rows.Row) interface{} {
+	panic("window function should be computed by window plan")
+}
+
+func (w *WindowExpr) Print() string {
+	var spec []string
+	if len(w.PartitionBy) != 0 {
+		spec = append(spec, "partition by "+printArgs(w.PartitionBy))
+	}
+	if len(w.OrderBy) != 0 {
+		var orders []string
+		for _, order := range w.OrderBy {
+			if order.Reverse {
+				orders = append(orders, order.Expr.Print()+" desc")
+			} else {
+				orders = append(orders, order.Expr.Print())
+			}
+		}
+		spec = append(spec, "order by "+strings.Join(orders, ", "))
+	}
+	if w.Frame != nil {
+		spec = append(spec, w.Frame.Print())
+	}
+	return fmt.Sprintf("%s over (%s)", w.Function.Print(), strings.Join(spec, " "))
+}
+
+func (w *WindowExpr) GetSchema(_ []rows.StructField) rows.StructField {
+	panic("window function can only be used in select list without group by")
+}
+
+func (w *WindowExpr) GetChildren() []*Expression {
+	result := []*Expression{&w.Function}
+	for i := range w.PartitionBy {
+		result = append(result, &w.PartitionBy[i])
+	}
+	for i := range w.OrderBy {
+		result = append(result, &w.OrderBy[i].Expr)
+	}
+	return result
+}
+
+func (f *WindowFrame) Print() string {
+	unit := "rows"
+	if f.Range {
+		unit = "range"
+	}
+	return fmt.Sprintf("%s between %s and %s", unit, f.Start.Print(), f.End.Print())
+}
+
+func (b FrameBound) Print() string {
+	switch b.Type {
+	case UnboundedPreceding:
+		return "unbounded preceding"
+	case Preceding:
+		return fmt.Sprintf("%d preceding", b.Offset)
+	case Following:
+		return fmt.Sprintf("%d following", b.Offset)
+	case UnboundedFollowing:
+		return "unbounded following"
+	}
+	return "current row"
+}
+
+// 分区内排好序的数据, 由 plan.Window 交给窗口函数计算
+type WindowPartition struct {
+	Rows   []rows.Row
+	Peers  []int    // 每一行所在的排序值相同的组的序号, 从 0 开始
+	Frames [][2]int // 每一行的窗口帧 [start, end)
+}
+
+// 只能在 over 中使用的函数, 根据整个分区计算每一行的结果, 结果为 nil 时表示 null
+type WindowFunction interface {
+	Expression
+	EvalWindow(partition *WindowPartition) []interface{}
+}
+
+type baseWindow struct{}
+
+func (b *baseWindow) Eval(_ rows.Row) interface{} {
+	panic("window function should be computed by window plan")
+}
+
+type RowNumber struct {
+	baseWindow
+	Args []Expression
+}
+
+func (r *RowNumber) EvalWindow(partition *WindowPartition) []interface{} {
+	result := make([]interface{}, len(partition.Rows))
+	for i := range result {
+		result[i] = pointer.Int64(int64(i + 1))
+	}
+	return result
+}
+
+func (r *RowNumber) Print() string {
+	return "row_number()"
+}
+
+func (r *RowNumber) GetSchema(_ []rows.StructField) rows.StructField {
+	checkArgCount("row_number", r.Args, 0, 0)
+	return rows.StructField{DataType: rows.Int}
+}
+
+func (r *RowNumber) GetChildren() []*Expression {
+	return []*Expression{}
+}
+
+// 排序值相同的行排名相同, 之后的排名会跳过
+type Rank struct {
+	baseWindow
+	Args []Expression
+}
+
+func (r *Rank) EvalWindow(partition *WindowPartition) []interface{} {
+	result := make([]interface{}, len(partition.Rows))
+	rank := 0
+	for i := range result {
+		if i == 0 || partition.Peers[i] != partition.Peers[i-1] {
+			rank = i + 1
+		}
+		result[i] = pointer.Int64(int64(rank))
+	}
+	return result
+}
+
+func (r *Rank) Print() string {
+	return "rank()"
+}
+
+func (r *Rank) GetSchema(_ []rows.StructField) rows.StructField {
+	checkArgCount("rank", r.Args, 0, 0)
+	return rows.StructField{DataType: rows.Int}
+}
+
+func (r *Rank) GetChildren() []*Expression {
+	return []*Expression{}
+}
+
+// 排序值相同的行排名相同, 之后的排名连续
+type DenseRank struct {
+	baseWindow
+	Args []Expression
+}
+
+func (d *DenseRank) EvalWindow(partition *WindowPartition) []interface{} {
+	result := make([]interface{}, len(partition.Rows))
+	for i := range result {
+		result[i] = pointer.Int64(int64(partition.Peers[i] + 1))
+	}
+	return result
+}
+
+func (d *DenseRank) Print() string {
+	return "dense_rank()"
+}
+
+func (d *DenseRank) GetSchema(_ []rows.StructField) rows.StructField {
+	checkArgCount("dense_rank", d.Args, 0, 0)
+	return rows.StructField{DataType: rows.Int}
+}
+
+func (d *DenseRank) GetChildren() []*Expression {
+	return []*Expression{}
+}
+
+// lag(expr [, offset [, default]]), 取之前第 offset 行的值
+type Lag struct {
+	baseWindow
+	Args []Expression
+}
+
+func (l *Lag) EvalWindow(partition *WindowPartition) []interface{} {
+	return evalOffset(l.Args, partition, -1)
+}
+
+func (l *Lag) Print() string {
+	return fmt.Sprintf("lag(%s)", printArgs(l.Args))
+}
+
+func (l *Lag) GetSchema(option []rows.StructField) rows.StructField {
+	return checkOffsetArgs("lag", l.Args, option)
+}
+
+func (l *Lag) GetChildren() []*Expression {
+	return argsChildren(l.Args)
+}
+
+// lead(expr [, offset [, default]]), 取之后第 offset 行的值
+type Lead struct {
+	baseWindow
+	Args []Expression
+}
+
+func (l *Lead) EvalWindow(partition *WindowPartition) []interface{} {
+	return evalOffset(l.Args, partition, 1)
+}
+
+func (l *Lead) Print() string {
+	return fmt.Sprintf("lead(%s)", printArgs(l.Args))
+}
+
+func (l *Lead) GetSchema(option []rows.StructField) rows.StructField {
+	return checkOffsetArgs("lead", l.Args, option)
+}
+
+func (l *Lead) GetChildren() []*Expression {
+	return argsChildren(l.Args)
+}
+
+// 窗口帧中第一行的值
+type FirstValue struct {
+	baseWindow
+	Args []Expression
+}
+
+func (f *FirstValue) EvalWindow(partition *WindowPartition) []interface{} {
+	result := make([]interface{}, len(partition.Rows))
+	for i, frame := range partition.Frames {
+		if frame[0] < frame[1] {
+			result[i] = f.Args[0].Eval(partition.Rows[frame[0]])
+		}
+	}
+	return result
+}
+
+func (f *FirstValue) Print() string {
+	return fmt.Sprintf("first_value(%s)", printArgs(f.Args))
+}
+
+func (f *FirstValue) GetSchema(option []rows.StructField) rows.StructField {
+	checkArgCount("first_value", f.Args, 1, 1)
+	return rows.StructField{DataType: f.Args[0].GetSchema(option).DataType}
+}
+
+func (f *FirstValue) GetChildren() []*Expression {
+	return argsChildren(f.Args)
+}
+
+// direction 为 -1 时向前取, 为 1 时向后取, 超出分区时使用默认值
+func evalOffset(args []Expression, partition *WindowPartition, direction int) []interface{} {
+	offset := 1
+	if len(args) > 1 {
+		offset = int(*args[1].Eval(nil).(*int64))
+	}
+	result := make([]interface{}, len(partition.Rows))
+	for i, row := range partition.Rows {
+		j := i + direction*offset
+		if j >= 0 && j < len(partition.Rows) {
+			result[i] = args[0].Eval(partition.Rows[j])
+		} else if len(args) > 2 {
+			result[i] = args[2].Eval(row)
+		}
+	}
+	return result
+}
+
+func checkOffsetArgs(name string, args []Expression, option []rows.StructField) rows.StructField {
+	checkArgCount(name, args, 1, 3)
+	field := args[0].GetSchema(option)
+	if len(args) > 1 {
+		if lit, ok := args[1].(*Literal); !ok || lit.Type != rows.Int || lit.IsNull || *lit.Eval(nil).(*int64) < 0 {
+			panic(fmt.Sprintf("offset of %s must be a non-negative integer", name))
+		}
+	}
+	if len(args) > 2 && args[2].GetSchema(option).DataType != field.DataType {
+		panic(fmt.Sprintf("default value of %s must be %s", name, rows.DataTypeName[field.DataType]))
+	}
+	return rows.StructField{DataType: field.DataType}
+}
+
+func checkArgCount(name string, args []Expression, min, max int) {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			panic(fmt.Sprintf("%s expects %d params", name, min))
+		}
+		panic(fmt.Sprintf("%s expects %d to %d params", name, min, max))
+	}
+}
+
+func printArgs(args []Expression) string {
+	var result []string
+	for _, arg := range args {
+		result = append(result, arg.Print())
+	}
+	return strings.Join(result, ", ")
+}
+
+func argsChildren(args []Expression) []*Expression {
+	var result []*Expression
+	for i := range args {
+		result = append(result, &args[i])
+	}
+	return result
+}
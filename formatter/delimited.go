@@ -0,0 +1,91 @@
+package formatter
+
+import (
+	"encoding/csv"
+	"io"
+	"sql-engine/rows"
+	"strings"
+)
+
+// 带表头的 csv, 按 RFC 4180 加引号, null 为空字段
+type csvFormatter struct{}
+
+func (csvFormatter) Format(w io.Writer, dataset rows.Dataset) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header(dataset)); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(cells(dataset, "")); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// 带表头的 tsv, 制表符、换行和反斜杠使用反斜杠转义, null 为 \N
+type tsvFormatter struct{}
+
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+func (tsvFormatter) Format(w io.Writer, dataset rows.Dataset) error {
+	var b strings.Builder
+	writeLine := func(values []string, escape bool) {
+		for i, value := range values {
+			if i > 0 {
+				b.WriteString("\t")
+			}
+			if escape {
+				value = tsvEscaper.Replace(value)
+			}
+			b.WriteString(value)
+		}
+		b.WriteString("\n")
+	}
+	writeLine(header(dataset), true)
+	for _, row := range dataset.Data {
+		var values []string
+		for i := range dataset.Schema {
+			if s, ok := text(row.IndexOf(i)); ok {
+				values = append(values, tsvEscaper.Replace(s))
+			} else {
+				values = append(values, "\\N")
+			}
+		}
+		writeLine(values, false)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdown 表格, 数字列右对齐, | 使用反斜杠转义
+type markdownFormatter struct{}
+
+var markdownEscaper = strings.NewReplacer("|", "\\|", "\n", "<br>", "\r", "")
+
+func (markdownFormatter) Format(w io.Writer, dataset rows.Dataset) error {
+	if len(dataset.Schema) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	writeLine := func(values []string) {
+		b.WriteString("|")
+		for _, value := range values {
+			b.WriteString(" " + markdownEscaper.Replace(value) + " |")
+		}
+		b.WriteString("\n")
+	}
+	writeLine(header(dataset))
+	b.WriteString("|")
+	for _, field := range dataset.Schema {
+		if isNumber(field.DataType) {
+			b.WriteString(" ---: |")
+		} else {
+			b.WriteString(" --- |")
+		}
+	}
+	b.WriteString("\n")
+	for _, values := range cells(dataset, "NULL") {
+		writeLine(values)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
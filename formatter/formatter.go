@@ -0,0 +1,114 @@
+package formatter
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sql-engine/rows"
+	"strconv"
+)
+
+// 将查询结果按指定格式输出
+type Formatter interface {
+	Format(w io.Writer, dataset rows.Dataset) error
+}
+
+var formatters = map[string]Formatter{
+	"table":    tableFormatter{},
+	"csv":      csvFormatter{},
+	"tsv":      tsvFormatter{},
+	"json":     jsonFormatter{},
+	"jsonl":    jsonLinesFormatter{},
+	"markdown": markdownFormatter{},
+}
+
+// 根据名称获取格式, 名称与配置项 output.format 一致
+func New(name string) (Formatter, error) {
+	if f, ok := formatters[name]; ok {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unknown output format: %s", name)
+}
+
+// 支持的格式名称
+func Names() []string {
+	var names []string
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func Format(w io.Writer, name string, dataset rows.Dataset) error {
+	f, err := New(name)
+	if err != nil {
+		return err
+	}
+	return f.Format(w, dataset)
+}
+
+// 取出指针中的值, null 时返回 nil
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case *string:
+		if t != nil {
+			return *t
+		}
+	case *int64:
+		if t != nil {
+			return *t
+		}
+	case *float64:
+		if t != nil {
+			return *t
+		}
+	case *bool:
+		if t != nil {
+			return *t
+		}
+	}
+	return nil
+}
+
+// 值的文本形式, 字符串不加引号, null 时返回 ok 为 false
+func text(v interface{}) (s string, ok bool) {
+	switch t := value(v).(type) {
+	case string:
+		return t, true
+	case int64:
+		return strconv.FormatInt(t, 10), true
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(t), true
+	}
+	return "", false
+}
+
+// 将每一行转为文本, null 使用 null 代替
+func cells(dataset rows.Dataset, null string) [][]string {
+	result := make([][]string, len(dataset.Data))
+	for r, row := range dataset.Data {
+		for i := range dataset.Schema {
+			s, ok := text(row.IndexOf(i))
+			if !ok {
+				s = null
+			}
+			result[r] = append(result[r], s)
+		}
+	}
+	return result
+}
+
+func header(dataset rows.Dataset) []string {
+	var result []string
+	for _, field := range dataset.Schema {
+		result = append(result, field.Name)
+	}
+	return result
+}
+
+func isNumber(t rows.DataType) bool {
+	return t == rows.Int || t == rows.Float
+}
@@ -0,0 +1,80 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"sql-engine/rows"
+)
+
+// json 数组, 每行为一个按 schema 顺序输出字段的对象, null 输出为 null, NaN 和 ±Inf 输出为字符串
+type jsonFormatter struct{}
+
+func (jsonFormatter) Format(w io.Writer, dataset rows.Dataset) error {
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i, row := range dataset.Data {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  ")
+		if err := writeObject(&b, dataset.Schema, row); err != nil {
+			return err
+		}
+	}
+	if len(dataset.Data) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("]\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// 每行一个 json 对象
+type jsonLinesFormatter struct{}
+
+func (jsonLinesFormatter) Format(w io.Writer, dataset rows.Dataset) error {
+	var b bytes.Buffer
+	for _, row := range dataset.Data {
+		if err := writeObject(&b, dataset.Schema, row); err != nil {
+			return err
+		}
+		b.WriteString("\n")
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// map 无法保证字段顺序, 这里逐个字段输出
+func writeObject(b *bytes.Buffer, schema []rows.StructField, row rows.Row) error {
+	b.WriteString("{")
+	for i, field := range schema {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		name, err := json.Marshal(field.Name)
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(jsonValue(row.IndexOf(i)))
+		if err != nil {
+			return err
+		}
+		b.Write(name)
+		b.WriteString(": ")
+		b.Write(v)
+	}
+	b.WriteString("}")
+	return nil
+}
+
+// json 不支持 NaN 和 ±Inf, 使用与其他格式相同的文本
+func jsonValue(v interface{}) interface{} {
+	result := value(v)
+	if f, ok := result.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		s, _ := text(v)
+		return s
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package formatter
+
+import (
+	"io"
+	"sql-engine/rows"
+	"strings"
+	"unicode"
+)
+
+// 对齐的 ascii 表格, 数字右对齐, null 显示为 NULL
+type tableFormatter struct{}
+
+func (tableFormatter) Format(w io.Writer, dataset rows.Dataset) error {
+	if len(dataset.Schema) == 0 {
+		return nil
+	}
+	names := header(dataset)
+	data := cells(dataset, "NULL")
+	widths := columnWidths(names, data)
+	var separator strings.Builder
+	separator.WriteString("+")
+	for _, width := range widths {
+		separator.WriteString(strings.Repeat("-", width+2) + "+")
+	}
+	separator.WriteString("\n")
+
+	var b strings.Builder
+	writeLine := func(values []string, rightAlign func(i int) bool) {
+		b.WriteString("|")
+		for i, value := range values {
+			padding := strings.Repeat(" ", widths[i]-displayWidth(value))
+			if rightAlign(i) {
+				b.WriteString(" " + padding + value + " |")
+			} else {
+				b.WriteString(" " + value + padding + " |")
+			}
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(separator.String())
+	writeLine(names, func(int) bool { return false })
+	b.WriteString(separator.String())
+	for _, values := range data {
+		writeLine(values, func(i int) bool { return isNumber(dataset.Schema[i].DataType) })
+	}
+	if len(data) > 0 {
+		b.WriteString(separator.String())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func columnWidths(names []string, data [][]string) []int {
+	widths := make([]int, len(names))
+	for i, name := range names {
+		widths[i] = displayWidth(name)
+	}
+	for _, values := range data {
+		for i, value := range values {
+			if n := displayWidth(value); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	return widths
+}
+
+// 终端中占两列的全角字符, 包括中日韩文字、全角符号和常见的 emoji
+var wideRanges = []struct{ lo, hi rune }{
+	{0x1100, 0x115F},
+	{0x2E80, 0x303E},
+	{0x3041, 0x33FF},
+	{0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF},
+	{0xAC00, 0xD7A3},
+	{0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F},
+	{0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6},
+	{0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF},
+	{0x20000, 0x2FFFD},
+	{0x30000, 0x3FFFD},
+}
+
+// 字符串在终端中的显示宽度, 全角字符占两列, 组合字符不占位置
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if unicode.In(r, unicode.Mn, unicode.Me) {
+			continue
+		}
+		width += 1
+		for _, w := range wideRanges {
+			if r >= w.lo && r <= w.hi {
+				width += 1
+				break
+			}
+		}
+	}
+	return width
+}
@@ -1,13 +1,57 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"sql-engine/config"
+	"sql-engine/formatter"
 	"sql-engine/parser"
+	"strings"
 )
 
 func main() {
-	sql := `select is_dir, sum(size) from '/Users/youbo/Downloads' group by is_dir`
-	dataSet := parser.ExecuteSql(sql, config.SQLConf{})
-	fmt.Println(dataSet.String())
+	execute := flag.String("e", "", "执行 sql 后退出, 多条语句使用 ';' 分隔")
+	file := flag.String("f", "", "执行 sql 脚本文件后退出")
+	confPath := flag.String("c", "", "配置文件路径")
+	output := flag.String("o", "", "输出格式: "+strings.Join(formatter.Names(), ", "))
+	flag.Parse()
+
+	conf, err := config.Load(*confPath)
+	if err == nil && *output != "" {
+		err = conf.Set("output.format", *output)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	shell := newShell(parser.NewSession(conf), os.Stdout, os.Stderr)
+
+	switch {
+	case *execute != "":
+		os.Exit(shell.runScript(*execute))
+	case *file != "":
+		script, err := ioutil.ReadFile(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(shell.runScript(string(script)))
+	case !isTerminal(os.Stdin):
+		// 从管道读取时作为脚本执行
+		script, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(shell.runScript(string(script)))
+	default:
+		shell.interactive(os.Stdin)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
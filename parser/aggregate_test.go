@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sql-engine/config"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 聚合 union all 时按子查询分区, 分区共用同一组表达式, 使用 go test -race 检查
+func TestAggregateUnionPartitions(t *testing.T) {
+	var dirs []string
+	for _, names := range [][]string{{"fa", "fb", "ga"}, {"fa", "fc"}, {"fb", "gb", "gc"}} {
+		dir, err := ioutil.TempDir("", "partition")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for _, name := range names {
+			if err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dirs = append(dirs, "select * from '"+dir+"'")
+	}
+	sql := "select regexp_extract(name, '(.)', 1) as k, count(1), count(distinct name) from (" +
+		strings.Join(dirs, " union all ") + ") as t group by k order by k"
+	dataset, err := ExecuteSql(context.Background(), sql, config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][3]string{{"f", "5", "3"}, {"g", "3", "3"}}
+	if len(dataset.Data) != len(expected) {
+		t.Fatalf("expect %d rows, got %d", len(expected), len(dataset.Data))
+	}
+	for i, row := range dataset.Data {
+		k := *row.IndexOf(0).(*string)
+		count := *row.IndexOf(1).(*int64)
+		distinct := *row.IndexOf(2).(*int64)
+		got := [3]string{k, strconv.FormatInt(count, 10), strconv.FormatInt(distinct, 10)}
+		if got != expected[i] {
+			t.Errorf("row %d: expect %v, got %v", i, expected[i], got)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"context"
+	"sql-engine/config"
+	"sql-engine/rows"
+	"sql-engine/sqlerr"
+	"sql-engine/util/pointer"
+	"strings"
+)
+
+// 非查询语句, 修改或读取会话状态
+type command interface {
+	run(ctx context.Context, s *Session) (rows.Dataset, error)
+}
+
+// set: 列出所有配置; set key: 查看配置; set key = value: 修改配置
+type setCommand struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// reset: 恢复所有配置; reset key: 恢复一个配置
+type resetCommand struct {
+	key string
+}
+
+// show settings
+type showSettingsCommand struct{}
+
+func (p *parser) wantSet() command {
+	if p.peek().Type == _EOF {
+		return &setCommand{}
+	}
+	cmd := &setCommand{key: p.wantSettingKey()}
+	if p.got(_Eql) {
+		cmd.value = p.wantSettingValue()
+		cmd.hasValue = true
+	}
+	return cmd
+}
+
+func (p *parser) wantReset() command {
+	if p.peek().Type == _EOF {
+		return &resetCommand{}
+	}
+	return &resetCommand{key: p.wantSettingKey()}
+}
+
+func (p *parser) wantShow() command {
+	if tok := p.peek(); tok.Type != _Name || strings.ToLower(tok.Value) != "settings" {
+		p.expectPanic("'settings'", tok)
+	}
+	p.got(_Name)
+	return &showSettingsCommand{}
+}
+
+func (p *parser) wantSettingKey() string {
+	p.want(_Name)
+	return strings.ToLower(p.tok().Value)
+}
+
+// 配置值为等号之后的原始文本, 以支持 30s、512M、fs,hdfs、/opt/Hadoop/bin/hadoop 这类不加引号的写法.
+// 只有一个字符串时使用字符串的值
+func (p *parser) wantSettingValue() string {
+	first := p.peek()
+	if first.Type == _EOF {
+		p.expectPanic("setting value", first)
+	}
+	for p.peek().Type != _EOF {
+		p.index += 1
+	}
+	if p.tok().start == first.start && first.Type == _StringLit {
+		return first.Value
+	}
+	return string(p.source[first.start:p.tok().end])
+}
+
+func (c *setCommand) run(_ context.Context, s *Session) (rows.Dataset, error) {
+	if c.key == "" {
+		return settingsDataset(s.conf), nil
+	}
+	if c.hasValue {
+		conf := s.conf
+		if err := conf.SetInSession(c.key, c.value); err != nil {
+			return rows.Dataset{}, sqlerr.NewAnalysisError(err.Error(), err)
+		}
+		s.conf = conf
+	}
+	return keyValueDataset(s.conf, c.key)
+}
+
+func (c *resetCommand) run(_ context.Context, s *Session) (rows.Dataset, error) {
+	if c.key == "" {
+		s.conf = s.initial
+		return settingsDataset(s.conf), nil
+	}
+	value, err := s.initial.Get(c.key)
+	if err != nil {
+		return rows.Dataset{}, sqlerr.NewAnalysisError(err.Error(), err)
+	}
+	conf := s.conf
+	if err = conf.Set(c.key, value); err != nil {
+		return rows.Dataset{}, sqlerr.NewAnalysisError(err.Error(), err)
+	}
+	s.conf = conf
+	return keyValueDataset(s.conf, c.key)
+}
+
+func (showSettingsCommand) run(_ context.Context, s *Session) (rows.Dataset, error) {
+	return settingsDataset(s.conf), nil
+}
+
+func keyValueDataset(conf config.SQLConf, key string) (rows.Dataset, error) {
+	value, err := conf.Get(key)
+	if err != nil {
+		return rows.Dataset{}, sqlerr.NewAnalysisError(err.Error(), err)
+	}
+	return rows.Dataset{
+		Data: []rows.Row{rows.New([]interface{}{pointer.String(strings.ToLower(key)), pointer.String(value)})},
+		Schema: []rows.StructField{
+			{Name: "key", DataType: rows.String},
+			{Name: "value", DataType: rows.String},
+		},
+	}, nil
+}
+
+// 所有配置项及说明
+func settingsDataset(conf config.SQLConf) rows.Dataset {
+	var data []rows.Row
+	for _, setting := range config.Settings() {
+		value, _ := conf.Get(setting.Key)
+		data = append(data, rows.New([]interface{}{
+			pointer.String(setting.Key),
+			pointer.String(value),
+			pointer.String(setting.Doc),
+		}))
+	}
+	return rows.Dataset{
+		Data: data,
+		Schema: []rows.StructField{
+			{Name: "key", DataType: rows.String},
+			{Name: "value", DataType: rows.String},
+			{Name: "description", DataType: rows.String},
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"sql-engine/plan"
+)
+
+// with 子句中定义的查询, 保存定义的 token, 每次引用时重新解析, 保证每个引用都是独立的 plan
+type cte struct {
+	name      string
+	tokens    []token
+	scope     map[string]*cte // 定义时可见的其他 with 子句
+	recursive bool
+
+	// 递归查询内部对自身的引用
+	workTable *plan.RecursiveCte
+}
+
+// with [recursive] name as (query) [, name as (query)]*, 后定义的可以引用之前定义的.
+// recursive 时在 from、join 中引用了自身的定义为递归查询
+func (p *parser) wantWith() {
+	recursive := p.got(_Recursive)
+	defined := make(map[string]bool)
+	for {
+		p.wantName()
+		name := p.tok()
+		if defined[name.Value] {
+			p.panicAt("duplicate name in with: "+name.Value, name.pos)
+		}
+		defined[name.Value] = true
+		p.want(_As)
+		p.want(_Lparen)
+		start := p.index
+		p.skipParenthesized()
+		def := &cte{
+			name:   name.Value,
+			tokens: p.tokens[start:p.index],
+			scope:  p.ctes,
+		}
+		def.recursive = recursive && referencesRelation(def.tokens, def.name)
+		// 先解析一次用于检查语法
+		p.expandCte(def)
+		p.want(_Rparen)
+		scope := make(map[string]*cte)
+		for k, v := range p.ctes {
+			scope[k] = v
+		}
+		scope[name.Value] = def
+		p.ctes = scope
+		if !p.got(_Comma) {
+			return
+		}
+	}
+}
+
+// name 是否作为表名出现在 from、join 之后
+func referencesRelation(tokens []token, name string) bool {
+	for i := 1; i < len(tokens); i++ {
+		prev := tokens[i-1].Type
+		isName := tokens[i].Type == _Name || softKeywords[tokens[i].Type]
+		if isName && tokens[i].Value == name && (prev == _From || prev == _Join) {
+			return true
+		}
+	}
+	return false
+}
+
+// 跳到与之前的 '(' 匹配的 ')', 不消费 ')'
+func (p *parser) skipParenthesized() {
+	depth := 0
+	for {
+		switch p.peek().Type {
+		case _EOF:
+			p.expectPanic("')'", p.peek())
+		case _Lparen:
+			depth += 1
+		case _Rparen:
+			if depth == 0 {
+				return
+			}
+			depth -= 1
+		}
+		p.index += 1
+	}
+}
+
+func (p *parser) expandCte(def *cte) plan.Plan {
+	if def.workTable != nil {
+		return &plan.WorkTable{Cte: def.workTable}
+	}
+	sub := &parser{
+		tokens: def.tokens,
+		conf:   p.conf,
+		ctes:   def.scope,
+	}
+	if !def.recursive {
+		result := sub.wantQuery()
+		sub.want(_EOF)
+		return result
+	}
+	// anchor union [all] recursive, anchor 中不能引用自身
+	result := &plan.RecursiveCte{
+		Name:     def.name,
+		Anchor:   sub.wantSelect(),
+		MaxDepth: p.conf.RecursionDepth,
+	}
+	sub.want(_Union)
+	result.Distinct = !sub.got(_All)
+	scope := make(map[string]*cte)
+	for k, v := range def.scope {
+		scope[k] = v
+	}
+	scope[def.name] = &cte{name: def.name, workTable: result}
+	sub.ctes = scope
+	result.Recursive = sub.wantSelect()
+	sub.want(_EOF)
+	return result
+}
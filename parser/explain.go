@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"context"
+	"sql-engine/plan"
+	"sql-engine/rows"
+	"sql-engine/util/pointer"
+)
+
+// explain [analyze] query: 输出解析、分析、优化后的 plan, analyze 时执行查询并输出每个节点的行数和耗时
+type explainCommand struct {
+	analyze bool
+	query   plan.Plan
+}
+
+func (c *explainCommand) run(ctx context.Context, s *Session) (rows.Dataset, error) {
+	var data []rows.Row
+	addStage := func(stage string, p plan.Plan) {
+		for _, line := range plan.TreeLines(p) {
+			data = append(data, rows.New([]interface{}{pointer.String(stage), pointer.String(line)}))
+		}
+	}
+	// 分析时会修改 plan, 需要先输出
+	p := c.query
+	addStage("parsed", p)
+	p, err := AnalysePlan(p)
+	if err != nil {
+		return rows.Dataset{}, err
+	}
+	addStage("analyzed", p)
+	if p, err = OptimizePlan(p); err != nil {
+		return rows.Dataset{}, err
+	}
+	addStage("optimized", p)
+	if c.analyze {
+		p = plan.Instrument(p)
+		if _, err = collect(ctx, p, s.conf); err != nil {
+			return rows.Dataset{}, err
+		}
+		addStage("executed", p)
+	}
+	return rows.Dataset{
+		Data: data,
+		Schema: []rows.StructField{
+			{Name: "stage", DataType: rows.String},
+			{Name: "plan", DataType: rows.String},
+		},
+	}, nil
+}
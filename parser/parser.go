@@ -7,45 +7,148 @@ import (
 	"sql-engine/plan"
 	"sql-engine/rows"
 	"sql-engine/source"
+	"sql-engine/sqlerr"
 	"strconv"
 )
 
 type parser struct {
 	index  int // 识别的 token 位置
+	source []rune
 	tokens []token
 	conf   config.SQLConf
+	ctes   map[string]*cte // 当前可见的 with 子句
 }
 
-func newParser(tokens []token, conf config.SQLConf) *parser {
+func newParser(source []rune, tokens []token, conf config.SQLConf) *parser {
 	return &parser{
+		source: source,
 		tokens: tokens,
 		conf:   conf,
 	}
 }
 
-func (p *parser) parse() plan.Plan {
-	result := p.wantQuery()
+// 解析一条语句, 查询语句返回 plan, 其他语句返回 command
+func (p *parser) parse() (plan.Plan, command) {
+	var cmd command
+	switch {
+	case p.got(_Set):
+		cmd = p.wantSet()
+	case p.got(_Reset):
+		cmd = p.wantReset()
+	case p.got(_Show):
+		cmd = p.wantShow()
+	case p.got(_Explain):
+		cmd = &explainCommand{analyze: p.got(_Analyze), query: p.wantQuery()}
+	default:
+		result := p.wantQuery()
+		p.want(_EOF)
+		return result, nil
+	}
 	p.want(_EOF)
-	return result
+	return nil, cmd
 }
 
 func (p *parser) wantQuery() plan.Plan {
-	plans := []plan.Plan{p.wantSelect()}
-	for p.got(_Union) {
-		p.want(_All)
-		plans = append(plans, p.wantSelect())
+	if p.got(_With) {
+		// with 定义只在当前查询内可见
+		outer := p.ctes
+		defer func() { p.ctes = outer }()
+		p.wantWith()
 	}
-	if len(plans) == 1 {
-		return plans[0]
+	result := p.wantIntersect()
+	for {
+		if p.got(_Union) {
+			all := p.got(_All)
+			result = p.union(result, p.wantIntersect(), all)
+		} else if p.got(_Except) {
+			all := p.got(_All)
+			result = &plan.Except{Left: result, Right: p.wantIntersect(), All: all}
+		} else {
+			break
+		}
 	}
-	return &plan.Union{
-		Children: plans,
+	// order by 和 limit 作用于整个查询, 而不是最后一个 select
+	return p.mayOrderAndLimit(result)
+}
+
+// [order by expr [asc|desc], ...] [limit n [offset m]]
+func (p *parser) mayOrderAndLimit(rootPlan plan.Plan) plan.Plan {
+	if p.got(_Order) {
+		p.want(_By)
+		genOrder := func() plan.SortOrder {
+			order := plan.SortOrder{Expr: p.wantExpression()}
+			if p.got(_Desc) {
+				order.Reverse = true
+			} else {
+				p.got(_Asc)
+			}
+			return order
+		}
+		orders := []plan.SortOrder{genOrder()}
+		for p.got(_Comma) {
+			orders = append(orders, genOrder())
+		}
+		rootPlan = &plan.Sort{
+			Child: rootPlan,
+			Order: orders,
+		}
+	}
+	if p.got(_Limit) {
+		p.want(_IntLit)
+		count, _ := strconv.Atoi(p.tok().Value)
+		limit := &plan.Limit{
+			Child: rootPlan,
+			Count: count,
+		}
+		if p.got(_Offset) {
+			p.want(_IntLit)
+			limit.Offset, _ = strconv.Atoi(p.tok().Value)
+		}
+		rootPlan = limit
 	}
+	return rootPlan
+}
+
+// intersect 优先级高于 union 和 except
+func (p *parser) wantIntersect() plan.Plan {
+	result := p.wantSelect()
+	for p.got(_Intersect) {
+		all := p.got(_All)
+		result = &plan.Intersect{Left: result, Right: p.wantSelect(), All: all}
+	}
+	return result
+}
+
+// 连续的 union 合并为一个 Union, union 不带 all 时对之前所有的结果去重
+func (p *parser) union(left, right plan.Plan, all bool) plan.Plan {
+	var children []plan.Plan
+	distinct, ok := left.(*plan.Distinct)
+	if ok {
+		if u, ok := distinct.Child.(*plan.Union); ok && !all {
+			children = append(u.Children, right)
+		}
+	}
+	if children == nil {
+		if u, ok := left.(*plan.Union); ok {
+			children = append(u.Children, right)
+		} else {
+			children = []plan.Plan{left, right}
+		}
+	}
+	var result plan.Plan = &plan.Union{
+		Children:    children,
+		Parallelism: p.conf.UnionParallelism,
+	}
+	if !all {
+		result = &plan.Distinct{Child: result}
+	}
+	return result
 }
 
 func (p *parser) wantSelect() plan.Plan {
 	var rootPlan plan.Plan
 	p.got(_Select)
+	distinct := p.got(_Distinct)
 	selectList := p.wantExpressionList(true)
 	p.want(_From)
 	dataSource := p.wantSource()
@@ -78,49 +181,84 @@ func (p *parser) wantSelect() plan.Plan {
 		}
 		rootPlan = agg
 	}
-	if p.got(_Order) {
-		p.want(_By)
-		genOrder := func() plan.SortOrder {
-			order := plan.SortOrder{Expr: p.wantExpression()}
-			if p.got(_Desc) {
-				order.Reverse = true
-			} else {
-				p.got(_Asc)
-			}
-			return order
-		}
-		orders := []plan.SortOrder{genOrder()}
-		for p.got(_Comma) {
-			orders = append(orders, genOrder())
-		}
-		rootPlan = &plan.Sort{
-			Child: rootPlan,
-			Order: orders,
+	if p.got(_Having) {
+		rootPlan = &plan.Filter{
+			Condition: p.wantExpression(),
+			Child:     rootPlan,
 		}
 	}
-	if p.got(_Limit) {
-		p.want(_IntLit)
-		count, _ := strconv.Atoi(p.tok().Value)
-		rootPlan = &plan.Limit{
-			Child: rootPlan,
-			Count: count,
-		}
+	if distinct {
+		rootPlan = &plan.Distinct{Child: rootPlan}
 	}
 	return rootPlan
 }
 
 func (p *parser) wantSource() plan.Plan {
-	if p.got(_StringLit) || p.got(_Name) {
+	left := p.wantRelation()
+	for {
+		joinType, ok := p.gotJoin()
+		if !ok {
+			return left
+		}
+		join := &plan.Join{
+			Left:     left,
+			Right:    p.wantRelation(),
+			JoinType: joinType,
+		}
+		if joinType != plan.CrossJoin {
+			p.want(_On)
+			join.Condition = p.wantExpression()
+		}
+		left = join
+	}
+}
+
+// 识别 join 关键字, 支持 [inner] join, left|right|full [outer] join, cross join
+func (p *parser) gotJoin() (plan.JoinType, bool) {
+	joinType := plan.InnerJoin
+	if p.got(_Left) {
+		joinType = plan.LeftOuterJoin
+		p.got(_Outer)
+	} else if p.got(_Right) {
+		joinType = plan.RightOuterJoin
+		p.got(_Outer)
+	} else if p.got(_Full) {
+		joinType = plan.FullOuterJoin
+		p.got(_Outer)
+	} else if p.got(_Cross) {
+		joinType = plan.CrossJoin
+	} else if !p.got(_Inner) {
+		return joinType, p.got(_Join)
+	}
+	p.want(_Join)
+	return joinType, true
+}
+
+func (p *parser) wantRelation() plan.Plan {
+	if p.got(_StringLit) || p.gotName() {
 		input := p.tok()
 		alias := ""
 		if p.got(_As) {
-			p.want(_Name)
+			p.wantName()
 			alias = p.tok().Value
 		}
+		if def, ok := p.ctes[input.Value]; ok && input.Type == _Name {
+			if alias == "" {
+				alias = input.Value
+			}
+			return &plan.Subquery{
+				Child: p.expandCte(def),
+				Alias: alias,
+			}
+		}
+		dataSource, err := source.NewSource(p.conf, input.Value)
+		if err != nil {
+			panic(err)
+		}
 		return &plan.Relation{
 			Input: input.Value,
 			Alias: alias,
-			DataSource: source.NewSource(p.conf, input.Value),
+			DataSource: dataSource,
 		}
 	}
 	// 以上条件不成立则必须为子查询
@@ -128,7 +266,7 @@ func (p *parser) wantSource() plan.Plan {
 	subquery := p.wantQuery()
 	p.want(_Rparen)
 	p.want(_As)
-	p.want(_Name)
+	p.wantName()
 	alias := p.tok().Value
 	return &plan.Subquery{
 		Child: subquery,
@@ -188,7 +326,7 @@ func (p *parser) wantExpression() expression.Expression {
 		}
 		if lit := p.wantLit(false); lit != nil {
 			queue = append(queue, lit)
-		} else if p.got(_Name) {
+		} else if p.got(_Name) || (p.expectOperand() && p.gotName()) {
 			queue = append(queue, &expression.Attribute{Name: p.tok().Value})
 		} else if p.got(_If) {
 			queue = append(queue, p.wantIf())
@@ -199,7 +337,13 @@ func (p *parser) wantExpression() expression.Expression {
 		} else if p.got(_Function) {
 			queue = append(queue, p.wantFunction())
 		} else if p.got(_Lparen) {
-			opStack.push(p.tok())
+			if p.peekQuery() {
+				query := p.wantQuery()
+				p.want(_Rparen)
+				queue = append(queue, &expression.ScalarSubquery{Query: query})
+			} else {
+				opStack.push(p.tok())
+			}
 		} else if p.got(_Rparen) {
 			hasLparen := false
 			// 左括号之后的都弹入放入队列
@@ -224,17 +368,21 @@ func (p *parser) wantExpression() expression.Expression {
 				}
 				p.panicAt("expect '(', expression start", startPos)
 			}
+		} else if p.got(_Exists) {
+			queue = append(queue, p.wantExists())
 		} else if p.got(_Not) {
 			if p.got(_In) {
 				queue = p.parseIn(queue, startPos)
 			} else if p.got(_Like) {
 				queue = p.parseLike(queue, startPos)
+			} else if p.got(_Exists) {
+				queue = append(queue, p.wantExists())
 			} else {
-				p.panicAt("expect 'in', 'like' after 'not'", startPos)
+				p.panicAt("expect 'in', 'like', 'exists' after 'not'", startPos)
 			}
 			//noinspection GoNilness
 			queue[len(queue)-1] = &expression.Not{Child: queue[len(queue)-1]}
-		} else if p.got(_In) { // todo 支持 not in
+		} else if p.got(_In) {
 			queue = p.parseIn(queue, startPos)
 		} else if p.got(_Like) {
 			queue = p.parseLike(queue, startPos)
@@ -310,10 +458,100 @@ func (p *parser) wantExpression() expression.Expression {
 
 func (p *parser) wantFunction() expression.Expression {
 	funcName := p.tok().Value
+	startPos := p.tok().pos
+	p.want(_Lparen)
+	distinct := p.got(_Distinct)
+	var args []expression.Expression
+	// row_number() 等没有参数的函数
+	if p.peek().Type != _Rparen {
+		args = p.wantExpressionList(false)
+	}
+	p.want(_Rparen)
+	f := expression.NewFuncByName(funcName, args)
+	if t, ok := f.(*expression.FromUnixTime); ok {
+		t.Location = p.conf.TimeZone
+	}
+	if distinct {
+		agg, ok := f.(expression.AggFunction)
+		if !ok {
+			p.panicAt("'distinct' can only be used in aggregate function", startPos)
+		}
+		//noinspection GoNilness
+		agg.SetDistinct(true)
+	}
+	if p.got(_Over) {
+		return p.wantWindow(f, startPos)
+	}
+	if _, ok := f.(expression.WindowFunction); ok {
+		p.panicAt(fmt.Sprintf("%s must be used with 'over'", f.Print()), startPos)
+	}
+	return f
+}
+
+// over ([partition by expr, ...] [order by expr [asc|desc], ...] [rows|range between bound and bound])
+func (p *parser) wantWindow(f expression.Expression, startPos pos) expression.Expression {
+	_, isWindow := f.(expression.WindowFunction)
+	if _, isAgg := f.(expression.AggFunction); !isWindow && !isAgg {
+		p.panicAt(fmt.Sprintf("%s can not be used with 'over'", f.Print()), startPos)
+	}
+	window := &expression.WindowExpr{Function: f}
 	p.want(_Lparen)
-	args := p.wantExpressionList(false)
+	if p.got(_Partition) {
+		p.want(_By)
+		window.PartitionBy = p.wantExpressionList(false)
+	}
+	if p.got(_Order) {
+		p.want(_By)
+		for {
+			order := expression.WindowOrder{Expr: p.wantExpression()}
+			if p.got(_Desc) {
+				order.Reverse = true
+			} else {
+				p.got(_Asc)
+			}
+			window.OrderBy = append(window.OrderBy, order)
+			if !p.got(_Comma) {
+				break
+			}
+		}
+	}
+	if p.got(_Rows) || p.got(_Range) {
+		frame := &expression.WindowFrame{Range: p.tok().Type == _Range}
+		// 省略 between 时只指定起点, 终点为当前行
+		if p.got(_Between) {
+			frame.Start = p.wantFrameBound()
+			p.want(_And)
+			frame.End = p.wantFrameBound()
+		} else {
+			frame.Start = p.wantFrameBound()
+			frame.End = expression.FrameBound{Type: expression.CurrentRow}
+		}
+		window.Frame = frame
+	}
 	p.want(_Rparen)
-	return expression.NewFuncByName(funcName, args)
+	return window
+}
+
+// unbounded preceding | n preceding | current row | n following | unbounded following
+func (p *parser) wantFrameBound() expression.FrameBound {
+	if p.got(_Current) {
+		p.want(_Row)
+		return expression.FrameBound{Type: expression.CurrentRow}
+	}
+	if p.got(_Unbounded) {
+		if p.got(_Preceding) {
+			return expression.FrameBound{Type: expression.UnboundedPreceding}
+		}
+		p.want(_Following)
+		return expression.FrameBound{Type: expression.UnboundedFollowing}
+	}
+	p.want(_IntLit)
+	offset, _ := strconv.ParseInt(p.tok().Value, 10, 64)
+	if p.got(_Preceding) {
+		return expression.FrameBound{Type: expression.Preceding, Offset: offset}
+	}
+	p.want(_Following)
+	return expression.FrameBound{Type: expression.Following, Offset: offset}
 }
 
 func (p *parser) parseLike(queue []expression.Expression, startPos pos) []expression.Expression {
@@ -347,9 +585,15 @@ func (p *parser) parseLike(queue []expression.Expression, startPos pos) []expres
 
 func (p *parser) parseIn(queue []expression.Expression, startPos pos) []expression.Expression {
 	p.want(_Lparen)
-	result := []expression.Expression{p.wantLit(true)}
-	for p.got(_Comma) {
+	var query plan.Plan
+	var result []expression.Expression
+	if p.peekQuery() {
+		query = p.wantQuery()
+	} else {
 		result = append(result, p.wantLit(true))
+		for p.got(_Comma) {
+			result = append(result, p.wantLit(true))
+		}
 	}
 	p.want(_Rparen)
 	if len(queue) == 0 {
@@ -360,6 +604,11 @@ func (p *parser) parseIn(queue []expression.Expression, startPos pos) []expressi
 	queue = queue[0 : len(queue)-1]
 	if attr, ok := e.(*expression.Attribute); !ok {
 		p.panicAt("expect attribute before 'in'", startPos)
+	} else if query != nil {
+		queue = append(queue, &expression.InSubquery{
+			Value: &expression.Attribute{Name: attr.Name},
+			Query: query,
+		})
 	} else {
 		queue = append(queue, &expression.In{
 			Value: &expression.Attribute{Name: attr.Name},
@@ -369,9 +618,26 @@ func (p *parser) parseIn(queue []expression.Expression, startPos pos) []expressi
 	return queue
 }
 
+// exists (select ...)
+func (p *parser) wantExists() expression.Expression {
+	p.want(_Lparen)
+	if !p.peekQuery() {
+		p.expectPanic("'select'", p.peek())
+	}
+	query := p.wantQuery()
+	p.want(_Rparen)
+	return &expression.Exists{Query: query}
+}
+
+// 下一个 token 是否为子查询的开始
+func (p *parser) peekQuery() bool {
+	t := p.peek().Type
+	return t == _Select || t == _With
+}
+
 func (p *parser) mayAlias(child expression.Expression) expression.Expression {
 	if p.got(_As) {
-		p.want(_Name)
+		p.wantName()
 		return &expression.Alias{
 			Child: child,
 			Name:  p.tok().Value,
@@ -481,6 +747,44 @@ func (p *parser) wantLit(needPanic bool) *expression.Literal {
 	return nil
 }
 
+// 只在窗口定义、limit 中有意义的关键字, 在其他位置可以作为名称使用, 如 select count(1) as rows
+var softKeywords = map[tokenType]bool{
+	_Partition: true,
+	_Rows:      true,
+	_Range:     true,
+	_Unbounded: true,
+	_Preceding: true,
+	_Following: true,
+	_Current:   true,
+	_Row:       true,
+	_Offset:    true,
+}
+
+// 下一个 token 为名称时消费, 作为名称的关键字转为 _Name
+func (p *parser) gotName() bool {
+	if tok := p.peek().Type; tok != _Name && !softKeywords[tok] {
+		return false
+	}
+	p.tokens[p.index].Type = _Name
+	p.index += 1
+	return true
+}
+
+func (p *parser) wantName() {
+	if !p.gotName() {
+		p.expectPanic(tokensName[_Name], p.peek())
+	}
+}
+
+// 上一个 token 之后应该是操作数, 此时关键字可以作为字段名
+func (p *parser) expectOperand() bool {
+	switch p.tok().Type {
+	case _Name, _IntLit, _FloatLit, _StringLit, _BooleanLit, _Null, _Rparen:
+		return false
+	}
+	return true
+}
+
 func (p *parser) got(tok tokenType) bool {
 	if p.peek().Type == tok {
 		p.index += 1
@@ -513,7 +817,13 @@ func (p *parser) tok() token {
 
 func (p *parser) peek() token {
 	if p.index < 0 || p.index >= len(p.tokens) {
+		// EOF 的位置记为最后一个 token 的位置, 方便报错
+		var position pos
+		if len(p.tokens) > 0 {
+			position = p.tokens[len(p.tokens)-1].pos
+		}
 		return token{
+			pos:   position,
 			Type:  _EOF,
 			Value: "EOF",
 		}
@@ -521,10 +831,15 @@ func (p *parser) peek() token {
 	return p.tokens[p.index]
 }
 
+// 语法错误时使用 panic 直接结束解析, 在 ParseSql 中 recover 转为 error
 func (p *parser) expectPanic(msg string, tok token) {
-	panic(fmt.Sprintf("expect %s, got (%s: %s) at (%d, %d)", msg, tok.Value, tokensName[tok.Type], tok.row, tok.col))
+	p.panicAt(fmt.Sprintf("expect %s, got (%s: %s)", msg, tok.Value, tokensName[tok.Type]), tok.pos)
 }
 
 func (p *parser) panicAt(msg string, position pos) {
-	panic(fmt.Sprintf("%s at (%d, %d)", msg, position.row, position.col))
+	panic(&sqlerr.SyntaxError{
+		Row: position.row,
+		Col: position.col,
+		Msg: msg,
+	})
 }
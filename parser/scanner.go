@@ -1,9 +1,9 @@
 package parser
 
 import (
-	"errors"
 	"fmt"
 	"sql-engine/expression"
+	"sql-engine/sqlerr"
 	"strings"
 )
 
@@ -15,9 +15,10 @@ type scanner struct {
 	// 当前指针行列位置
 	row int
 	col int
-	// 用来记录一个 token 开始的行列
+	// 用来记录一个 token 开始的行列和位置
 	startRow int
 	startCol int
+	start    int
 
 	// 记录 token 的类型和值
 	_type  tokenType
@@ -98,7 +99,7 @@ func (s *scanner) next() (*token, error) {
 	case '!':
 		if s.getr() != '=' {
 			s.ungetr()
-			return nil, errors.New("unknown symbol: ! ")
+			return nil, s.error("unknown symbol: ! ")
 		}
 		s.setTokenInfo(_Neq, "!=")
 	case '>':
@@ -116,7 +117,7 @@ func (s *scanner) next() (*token, error) {
 			s.setTokenInfo(_Lss, "<")
 		}
 	default:
-		return nil, errors.New("unknown: " + string(char))
+		return nil, s.error("unknown: " + string(char))
 	}
 	return s.newToken(), nil
 }
@@ -140,7 +141,8 @@ func (s *scanner) ident() (*token, error) {
 		if tt == _True || tt == _False {
 			t = _BooleanLit
 		}
-	} else if _, ok := expression.FuncMap[strings.ToLower(string(lit))]; ok {
+	} else if _, ok := expression.FuncMap[strings.ToLower(string(lit))]; ok && s.peekNonSpace() == '(' {
+		// 后面没有 '(' 时作为名称, 如 rank() over (...) as rank
 		t = _Function
 	}
 	s.setTokenInfo(t, string(lit))
@@ -161,7 +163,7 @@ func (s *scanner) number() (*token, error) {
 	s.ungetr()
 	num := s.stopLit()
 	if num[len(num)-1] == '.' {
-		return nil, errors.New(fmt.Sprintf("%s is not normal number", string(num)))
+		return nil, s.error(fmt.Sprintf("%s is not normal number", string(num)))
 	}
 	t := _IntLit
 	if hasDot {
@@ -181,7 +183,7 @@ func (s *scanner) stdString() (*token, error) {
 			break
 		}
 		if c < 0 {
-			return nil, errors.New(fmt.Sprintf("string not terminated: %s", string(s.stopLit()[1:])))
+			return nil, s.error(fmt.Sprintf("string not terminated: %s", string(s.source[s.startAt+1:])))
 		}
 	}
 	str := s.stopLit()
@@ -205,6 +207,16 @@ func (s *scanner) lineComment() (*token, error) {
 	return s.newToken(), nil
 }
 
+// 之后第一个非空白字符, 不移动位置
+func (s *scanner) peekNonSpace() rune {
+	for i := s.pos + 1; i < len(s.source); i++ {
+		if c := s.source[i]; c != ' ' && c != '\n' && c != '\t' && c != '\r' {
+			return c
+		}
+	}
+	return -1
+}
+
 func (s *scanner) getr() rune {
 	s.pos += 1
 	if s.pos >= len(s.source) {
@@ -249,6 +261,7 @@ func (s *scanner) stopLit() []rune {
 func (s *scanner) startPos() {
 	s.startRow = s.row
 	s.startCol = s.col
+	s.start = s.pos
 }
 
 func (s *scanner) setTokenInfo(t tokenType, v string) {
@@ -264,6 +277,17 @@ func (s *scanner) newToken() *token {
 		},
 		Type:  s._type,
 		Value: s._value,
+		start: s.start,
+		end:   s.pos + 1,
+	}
+}
+
+// 当前 token 位置的语法错误
+func (s *scanner) error(msg string) error {
+	return &sqlerr.SyntaxError{
+		Row: s.startRow + 1,
+		Col: s.startCol,
+		Msg: msg,
 	}
 }
 
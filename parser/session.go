@@ -0,0 +1,38 @@
+package parser
+
+import (
+	"context"
+	"sql-engine/config"
+	"sql-engine/rows"
+)
+
+// 会话, 在多次执行之间保留 set 修改的配置, 不能并发使用
+type Session struct {
+	conf    config.SQLConf
+	initial config.SQLConf // reset 时恢复的配置
+}
+
+func NewSession(conf config.SQLConf) *Session {
+	conf = conf.WithDefaults()
+	return &Session{
+		conf:    conf,
+		initial: conf,
+	}
+}
+
+// 当前配置
+func (s *Session) Conf() config.SQLConf {
+	return s.conf
+}
+
+// 执行一条查询语句或 set、reset、show、explain 语句
+func (s *Session) Execute(ctx context.Context, sql string) (rows.Dataset, error) {
+	p, cmd, err := parseStatement(sql, s.conf)
+	if err != nil {
+		return rows.Dataset{}, err
+	}
+	if cmd != nil {
+		return cmd.run(ctx, s)
+	}
+	return executeQuery(ctx, p, s.conf)
+}
@@ -1,9 +1,12 @@
 package parser
 
 import (
+	"context"
 	"sql-engine/config"
 	"sql-engine/plan"
 	"sql-engine/rows"
+	"sql-engine/sqlerr"
+	"strings"
 )
 
 type Strategy int
@@ -19,7 +22,13 @@ type Batch struct {
 }
 
 var analysisBatches = []Batch{
+	{Rule: plan.RewriteSubqueryPredicate{}},
+	{Rule: plan.ResolveScalarSubquery{}},
+	{Rule: plan.ExtractWindowExpressions{}},
+	{Rule: plan.ResolveGroupByReferences{}},
 	{Rule: plan.PureAggregateReplace{}},
+	{Rule: plan.ResolveAggregateInHaving{}},
+	{Rule: plan.ResolveSortReferences{}},
 	{Rule: plan.CheckAggregateUse{}},
 	{Rule: plan.ProxyExprInAggregate{}},
 	{Rule: plan.CheckSchema{}},
@@ -27,34 +36,93 @@ var analysisBatches = []Batch{
 }
 var optimizeBatches = []Batch{
 	{Rule: plan.PushDownPredicateIntoSource{}},
+	{Rule: plan.ExtractEquiJoinKeys{}},
+	{Rule: plan.PartitionAggregateByUnion{}},
 }
 
-func ParseSql(sql string, conf config.SQLConf) plan.Plan {
+// 解析查询语句, set 等非查询语句需要通过 Session 执行
+func ParseSql(sql string, conf config.SQLConf) (plan.Plan, error) {
+	result, cmd, err := parseStatement(sql, conf)
+	if err != nil {
+		return nil, err
+	}
+	if cmd != nil {
+		return nil, &sqlerr.SyntaxError{Row: 1, Msg: "expect query statement, use Session to execute command"}
+	}
+	return result, nil
+}
+
+func parseStatement(sql string, conf config.SQLConf) (result plan.Plan, cmd command, err error) {
+	conf = conf.WithDefaults()
 	s := newScanner(sql)
 	tokens, err := s.tokens()
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	p := newParser(tokens, conf)
-	return p.parse()
+	// 不区分大小写时标识符统一转为小写
+	if conf.IgnoreCase {
+		for i := range tokens {
+			if tokens[i].Type == _Name || softKeywords[tokens[i].Type] {
+				tokens[i].Value = strings.ToLower(tokens[i].Value)
+			}
+		}
+	}
+	p := newParser(s.source, tokens, conf)
+	defer func() {
+		if r := recover(); r != nil {
+			err = sqlerr.FromPanic(r, func(msg string, _ error) error {
+				tok := p.peek()
+				return &sqlerr.SyntaxError{Row: tok.row, Col: tok.col, Msg: msg}
+			})
+		}
+	}()
+	result, cmd = p.parse()
+	return result, cmd, nil
 }
 
-func ExecuteSql(sql string, conf config.SQLConf) rows.Dataset {
-	p := ParseSql(sql, conf)
-	p = AnalysePlan(p)
-	p = OptimizePlan(p)
-	return p.Execute()
+// 使用临时的会话执行一条语句, set 修改的配置不会保留.
+// ctx 取消或者超过 conf.QueryTimeout 时查询结束并返回错误
+func ExecuteSql(ctx context.Context, sql string, conf config.SQLConf) (rows.Dataset, error) {
+	return NewSession(conf).Execute(ctx, sql)
 }
 
-func AnalysePlan(p plan.Plan) plan.Plan {
+func executeQuery(ctx context.Context, p plan.Plan, conf config.SQLConf) (rows.Dataset, error) {
+	var err error
+	if p, err = AnalysePlan(p); err != nil {
+		return rows.Dataset{}, err
+	}
+	if p, err = OptimizePlan(p); err != nil {
+		return rows.Dataset{}, err
+	}
+	return collect(ctx, p, conf)
+}
+
+// 按配置中的超时时间和内存上限执行 plan
+func collect(ctx context.Context, p plan.Plan, conf config.SQLConf) (rows.Dataset, error) {
+	if conf.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.QueryTimeout)
+		defer cancel()
+	}
+	ctx = plan.WithMemoryLimit(ctx, conf.MemoryLimit)
+	return plan.Collect(ctx, p)
+}
+
+func AnalysePlan(p plan.Plan) (plan.Plan, error) {
 	return executeBatch(p, analysisBatches)
 }
 
-func OptimizePlan(p plan.Plan) plan.Plan {
+func OptimizePlan(p plan.Plan) (plan.Plan, error) {
 	return executeBatch(p, optimizeBatches)
 }
 
-func executeBatch(p plan.Plan, batches []Batch) plan.Plan {
+// 规则中检查失败时使用 panic, 在这里转为 AnalysisError
+func executeBatch(p plan.Plan, batches []Batch) (result plan.Plan, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = sqlerr.FromPanic(r, sqlerr.NewAnalysisError)
+		}
+	}()
 	for _, batch := range batches {
 		if batch.Strategy == Once {
 			p = batch.Rule.Apply(p)
@@ -65,5 +133,5 @@ func executeBatch(p plan.Plan, batches []Batch) plan.Plan {
 			}
 		}
 	}
-	return p
+	return p, nil
 }
@@ -66,6 +66,30 @@ const (
 	_True
 	_False
 	_Like
+	_Inner
+	_Cross
+	_Having
+	_Set
+	_Reset
+	_Show
+	_Explain
+	_Analyze
+	_With
+	_Recursive
+	_Exists
+	_Over
+	_Partition
+	_Rows
+	_Range
+	_Between
+	_Unbounded
+	_Preceding
+	_Following
+	_Current
+	_Row
+	_Intersect
+	_Except
+	_Offset
 )
 
 type pos struct {
@@ -77,6 +101,8 @@ type token struct {
 	pos
 	Type  tokenType
 	Value string
+	start int // 在源码中的位置 [start, end), 用于读取原始文本
+	end   int
 }
 
 // 运算符优先级
@@ -141,6 +167,30 @@ var keywordMap = map[string]tokenType{
 	"true":     _True,
 	"false":    _False,
 	"like":		_Like,
+	"inner":    _Inner,
+	"cross":    _Cross,
+	"having":   _Having,
+	"set":      _Set,
+	"reset":    _Reset,
+	"show":     _Show,
+	"explain":  _Explain,
+	"analyze":  _Analyze,
+	"with":      _With,
+	"recursive": _Recursive,
+	"exists":    _Exists,
+	"over":      _Over,
+	"partition": _Partition,
+	"rows":      _Rows,
+	"range":     _Range,
+	"between":   _Between,
+	"unbounded": _Unbounded,
+	"preceding": _Preceding,
+	"following": _Following,
+	"current":   _Current,
+	"row":       _Row,
+	"intersect": _Intersect,
+	"except":    _Except,
+	"offset":    _Offset,
 }
 
 var tokensName = map[tokenType]string{
@@ -203,4 +253,28 @@ var tokensName = map[tokenType]string{
 	_True:      "true",
 	_False:     "false",
 	_Like:		"like",
+	_Inner:     "inner",
+	_Cross:     "cross",
+	_Having:    "having",
+	_Set:       "set",
+	_Reset:     "reset",
+	_Show:      "show",
+	_Explain:   "explain",
+	_Analyze:   "analyze",
+	_With:      "with",
+	_Recursive: "recursive",
+	_Exists:    "exists",
+	_Over:      "over",
+	_Partition: "partition",
+	_Rows:      "rows",
+	_Range:     "range",
+	_Between:   "between",
+	_Unbounded: "unbounded",
+	_Preceding: "preceding",
+	_Following: "following",
+	_Current:   "current",
+	_Row:       "row",
+	_Intersect: "intersect",
+	_Except:    "except",
+	_Offset:    "offset",
 }
@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"sql-engine/expression"
+	"sql-engine/sqlerr"
 )
 
 type tokenStack struct {
@@ -67,6 +68,10 @@ func (s *tokenStack) popAsExpr() expression.BinaryOp {
 	case _Or:
 		return &expression.Or{BinaryExpr: expression.BinaryExpr{}}
 	default:
-		panic(fmt.Sprintf("is not a binary expression: (%s: %s)", t.Value, tokensName[t.Type]))
+		panic(&sqlerr.SyntaxError{
+			Row: t.row,
+			Col: t.col,
+			Msg: fmt.Sprintf("is not a binary expression: (%s: %s)", t.Value, tokensName[t.Type]),
+		})
 	}
 }
@@ -1,12 +1,328 @@
 package plan
 
 import (
+	"fmt"
 	"reflect"
 	"sql-engine/expression"
 	"sql-engine/rows"
+	"strconv"
 	"strings"
 )
 
+// where 中以 and 连接的 in (subquery)、exists 改写为 semi join, not in、not exists 改写为 anti join
+type RewriteSubqueryPredicate struct{}
+
+func (RewriteSubqueryPredicate) Apply(plan Plan) Plan {
+	count := 0
+	var rewrite func(plan Plan) Plan
+	rewrite = func(plan Plan) Plan {
+		return Transform(plan, func(p Plan) Plan {
+			filter, ok := p.(*Filter)
+			if !ok {
+				return p
+			}
+			// having 产生的 filter 不改写
+			switch filter.Child.(type) {
+			case *Aggregate, *Project:
+				return p
+			}
+			var rest []expression.Expression
+			var joins []*Join
+			for _, condition := range splitConjunctivePredicates(filter.Condition) {
+				e, negate := condition, false
+				if not, ok := e.(*expression.Not); ok {
+					e, negate = not.Child, true
+				}
+				var join *Join
+				switch t := e.(type) {
+				case *expression.InSubquery:
+					count += 1
+					join = rewriteInSubquery(t, filter.Child.GetSchema(), rewrite(t.Query.(Plan)), "$subquery_"+strconv.Itoa(count))
+					// x not in (...) 遇到 null 时结果为 null
+					join.NullAware = negate
+				case *expression.Exists:
+					count += 1
+					join = rewriteExists(rewrite(t.Query.(Plan)), "$subquery_"+strconv.Itoa(count))
+				default:
+					rest = append(rest, condition)
+					continue
+				}
+				if negate {
+					join.JoinType = LeftAntiJoin
+				}
+				joins = append(joins, join)
+			}
+			if len(joins) == 0 {
+				return p
+			}
+			// 普通条件先过滤, 便于下推至 relation
+			result := filter.Child
+			if len(rest) != 0 {
+				result = &Filter{Condition: combineConjunctivePredicates(rest), Child: filter.Child}
+			}
+			for _, join := range joins {
+				join.Left = result
+				result = join
+			}
+			return result
+		})
+	}
+	return rewrite(plan)
+}
+
+// 表达式中的标量子查询作为 ScalarSubqueries 的子节点, 在查询执行前计算一次
+type ResolveScalarSubquery struct{}
+
+func (r ResolveScalarSubquery) Apply(plan Plan) Plan {
+	var exprs []*expression.ScalarSubquery
+	Transform(plan, func(p Plan) Plan {
+		for _, e := range planExpressions(p) {
+			expression.Transform(e, func(expr expression.Expression) expression.Expression {
+				if s, ok := expr.(*expression.ScalarSubquery); ok {
+					exprs = append(exprs, s)
+				}
+				return expr
+			})
+		}
+		return p
+	})
+	if len(exprs) == 0 {
+		return plan
+	}
+	node := &ScalarSubqueries{Child: plan, exprs: exprs}
+	for i, e := range exprs {
+		node.Subqueries = append(node.Subqueries, r.Apply(e.Query.(Plan)))
+		e.Query = subqueryRef{node: node, index: i}
+	}
+	return node
+}
+
+// 子查询在之后的分析中可能被替换, 表达式通过下标引用
+type subqueryRef struct {
+	node  *ScalarSubqueries
+	index int
+}
+
+func (r subqueryRef) GetSchema() []rows.StructField {
+	return r.node.Subqueries[r.index].GetSchema()
+}
+
+// plan 节点自身持有的表达式
+func planExpressions(p Plan) []expression.Expression {
+	var exprs []expression.Expression
+	switch t := p.(type) {
+	case *Project:
+		exprs = append(exprs, t.ProjectList...)
+	case *Filter:
+		exprs = append(exprs, t.Condition)
+	case *Aggregate:
+		exprs = append(append(exprs, t.GroupExprs...), t.AggregateExprs...)
+	case *Join:
+		if t.Condition != nil {
+			exprs = append(exprs, t.Condition)
+		}
+	case *Sort:
+		for _, order := range t.Order {
+			exprs = append(exprs, order.Expr)
+		}
+	case *Window:
+		exprs = append(exprs, t.WindowExprs...)
+	}
+	return exprs
+}
+
+// 子查询的字段重命名为 $subquery_N.$value, 外部的字段使用完整名称, 避免 join 后重名
+func rewriteInSubquery(in *expression.InSubquery, outer []rows.StructField, query Plan, alias string) *Join {
+	schema := query.GetSchema()
+	if len(schema) != 1 {
+		panic("subquery of 'in' must return exactly one column")
+	}
+	right := &Subquery{
+		Child: &Project{
+			ProjectList: []expression.Expression{&expression.Alias{
+				Child: &expression.Attribute{Name: schema[0].Name},
+				Name:  "$value",
+			}},
+			Child: query,
+		},
+		Alias: alias,
+	}
+	return &Join{
+		Right:    right,
+		JoinType: LeftSemiJoin,
+		Condition: &expression.EqualTo{BinaryExpr: expression.BinaryExpr{
+			Left:  qualify(in.Value, outer),
+			Right: &expression.Attribute{Name: alias + ".$value"},
+		}},
+	}
+}
+
+// 子查询 where 中引用外部字段的条件作为 join 条件, 其余部分作为 join 的右侧
+func rewriteExists(query Plan, alias string) *Join {
+	if project, ok := query.(*Project); ok && !hasAggregate(project.ProjectList) {
+		if filter, ok := project.Child.(*Filter); ok {
+			inner := filter.Child.GetSchema()
+			var local, correlated []expression.Expression
+			for _, condition := range splitConjunctivePredicates(filter.Condition) {
+				if resolvable(condition, inner) {
+					local = append(local, condition)
+				} else {
+					correlated = append(correlated, condition)
+				}
+			}
+			if len(correlated) != 0 {
+				right := filter.Child
+				if len(local) != 0 {
+					right = &Filter{Condition: combineConjunctivePredicates(local), Child: filter.Child}
+				}
+				// 子查询中的字段优先, 使用完整名称避免与外部字段重名
+				condition := qualify(combineConjunctivePredicates(correlated), inner)
+				return &Join{Right: right, JoinType: LeftSemiJoin, Condition: condition}
+			}
+		}
+	}
+	// 不相关子查询, 使用别名避免与外部字段重名
+	return &Join{Right: &Subquery{Child: query, Alias: alias}, JoinType: LeftSemiJoin}
+}
+
+// 表达式中能在 schema 中找到的字段替换为 schema 中的完整名称
+func qualify(expr expression.Expression, schema []rows.StructField) expression.Expression {
+	return expression.Transform(expr, func(e expression.Expression) expression.Expression {
+		if attr, ok := e.(*expression.Attribute); ok && resolvable(attr, schema) {
+			return &expression.Attribute{Name: schema[attr.Index()].Name}
+		}
+		return e
+	})
+}
+
+// 表达式引用的字段是否都能在 schema 中找到
+func resolvable(expr expression.Expression, schema []rows.StructField) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	expression.Transform(expr, func(e expression.Expression) expression.Expression {
+		if attr, ok := e.(*expression.Attribute); ok {
+			attr.GetSchema(schema)
+		}
+		return e
+	})
+	return true
+}
+
+func hasAggregate(exprs []expression.Expression) bool {
+	exist := false
+	for _, e := range exprs {
+		expression.Transform(e, func(expr expression.Expression) expression.Expression {
+			if _, ok := expr.(expression.AggFunction); ok {
+				exist = true
+			}
+			return expr
+		})
+	}
+	return exist
+}
+
+// select 中的窗口函数由 Window 计算后作为新字段, 分区和排序相同的窗口函数在同一个 Window 中计算
+type ExtractWindowExpressions struct{}
+
+func (ExtractWindowExpressions) Apply(plan Plan) Plan {
+	count := 0
+	return Transform(plan, func(p Plan) Plan {
+		project, ok := p.(*Project)
+		if !ok {
+			return p
+		}
+		var windows []*Window
+		specs := make(map[string]*Window)
+		extract := func(expr expression.Expression) expression.Expression {
+			windowExpr, ok := expr.(*expression.WindowExpr)
+			if !ok {
+				return expr
+			}
+			var order []SortOrder
+			var spec []string
+			for _, o := range windowExpr.OrderBy {
+				order = append(order, SortOrder{Expr: o.Expr, Reverse: o.Reverse})
+				spec = append(spec, o.Expr.Print()+strconv.FormatBool(o.Reverse))
+			}
+			key := printExprs(windowExpr.PartitionBy) + "|" + strings.Join(spec, ", ")
+			window, ok := specs[key]
+			if !ok {
+				window = &Window{PartitionBy: windowExpr.PartitionBy, Order: order}
+				specs[key] = window
+				windows = append(windows, window)
+			}
+			count += 1
+			name := "window_$" + strconv.Itoa(count)
+			window.WindowExprs = append(window.WindowExprs, &expression.Alias{Child: windowExpr, Name: name})
+			return &expression.Attribute{Name: name}
+		}
+		for i, e := range project.ProjectList {
+			// 包含窗口函数且没有别名时以原表达式作为字段名, 避免输出内部的 window_$N
+			name, before := e.Print(), count
+			project.ProjectList[i] = expression.Transform(e, extract)
+			if _, ok := e.(*expression.Alias); !ok && count != before {
+				project.ProjectList[i] = &expression.Alias{Child: project.ProjectList[i], Name: name}
+			}
+		}
+		// 窗口函数之外还有聚合函数时会整体转为聚合, 窗口函数的结果不在分组中
+		if len(windows) > 0 && hasAggregate(project.ProjectList) {
+			panic("window function can only be used in select list without group by")
+		}
+		for _, window := range windows {
+			window.Child = project.Child
+			project.Child = window
+		}
+		return p
+	})
+}
+
+// group by 中的位置和 select 中的别名替换为对应的 select 表达式
+type ResolveGroupByReferences struct{}
+
+func (ResolveGroupByReferences) Apply(plan Plan) Plan {
+	return Transform(plan, func(p Plan) Plan {
+		agg, ok := p.(*Aggregate)
+		if !ok {
+			return p
+		}
+		for i, e := range agg.GroupExprs {
+			var target expression.Expression
+			if n, ok := ordinal(e); ok {
+				if n < 1 || n > len(agg.AggregateExprs) {
+					panic(fmt.Sprintf("group by position %d is not in select list", n))
+				}
+				target = agg.AggregateExprs[n-1]
+				if _, ok := target.(*expression.Star); ok {
+					panic(fmt.Sprintf("group by position %d refers to '*'", n))
+				}
+			} else if attr, ok := e.(*expression.Attribute); ok && !resolvable(attr, agg.Child.GetSchema()) {
+				// 字段优先, 找不到时再匹配别名
+				for _, expr := range agg.AggregateExprs {
+					if alias, ok := expr.(*expression.Alias); ok && alias.Name == attr.Name {
+						target = alias
+						break
+					}
+				}
+			}
+			if target == nil {
+				continue
+			}
+			if alias, ok := target.(*expression.Alias); ok {
+				target = alias.Child
+			}
+			if hasAggregate([]expression.Expression{target}) {
+				panic("can not group by aggregate function: " + target.Print())
+			}
+			// 分组表达式与 select 中的表达式会被分别解析, 不能共用
+			agg.GroupExprs[i] = expression.Copy(target)
+		}
+		return p
+	})
+}
+
 // 如果 select 里存在聚合函数，那么替换为 agg
 type PureAggregateReplace struct{}
 
@@ -14,16 +330,7 @@ func (PureAggregateReplace) Apply(plan Plan) Plan {
 	return Transform(plan, func(p Plan) Plan {
 		if project, ok := p.(*Project); ok {
 			// project 里存在聚合函数
-			existAgg := false
-			for _, e := range project.ProjectList {
-				expression.Transform(e, func(expr expression.Expression) expression.Expression {
-					if _, ok := expr.(expression.AggFunction); ok {
-						existAgg = true
-					}
-					return expr
-				})
-			}
-			if !existAgg {
+			if !hasAggregate(project.ProjectList) {
 				return p
 			}
 			var one int64 = 1
@@ -40,6 +347,153 @@ func (PureAggregateReplace) Apply(plan Plan) Plan {
 	})
 }
 
+// having 产生的 filter 中的聚合函数和 select 中没有的字段，作为隐藏列加入 aggregate 中计算，
+// filter 中引用改为对 aggregate 输出字段的引用, 最后使用 project 去除隐藏列
+type ResolveAggregateInHaving struct{}
+
+func (ResolveAggregateInHaving) Apply(plan Plan) Plan {
+	return Transform(plan, func(p Plan) Plan {
+		filter, ok := p.(*Filter)
+		if !ok {
+			return p
+		}
+		agg, ok := filter.Child.(*Aggregate)
+		if !ok {
+			return p
+		}
+		var outputs []expression.Expression
+		names := make(map[string]bool)
+		for _, field := range agg.GetSchema() {
+			outputs = append(outputs, &expression.Attribute{Name: field.Name})
+			names[field.Name] = true
+		}
+		hiddenCount := 0
+		// 优先复用 select 中相同的表达式
+		reference := func(e expression.Expression) expression.Expression {
+			for i, expr := range agg.AggregateExprs {
+				if alias, ok := expr.(*expression.Alias); ok {
+					expr = alias.Child
+				}
+				if i < len(outputs) && expr.Print() == e.Print() {
+					return &expression.Attribute{Name: outputs[i].(*expression.Attribute).Name}
+				}
+			}
+			hiddenCount += 1
+			name := "having_$" + strconv.Itoa(hiddenCount)
+			agg.AggregateExprs = append(agg.AggregateExprs, &expression.Alias{Child: e, Name: name})
+			return &expression.Attribute{Name: name}
+		}
+		var resolve func(e expression.Expression) expression.Expression
+		resolve = func(e expression.Expression) expression.Expression {
+			if _, ok := e.(expression.AggFunction); ok {
+				return reference(e)
+			}
+			if attr, ok := e.(*expression.Attribute); ok && !names[attr.Name] {
+				return reference(e)
+			}
+			for _, child := range e.GetChildren() {
+				*child = resolve(*child)
+			}
+			return e
+		}
+		filter.Condition = resolve(filter.Condition)
+		if hiddenCount == 0 {
+			return p
+		}
+		agg.schemaCache = nil
+		return &Project{
+			ProjectList: outputs,
+			Child:       filter,
+		}
+	})
+}
+
+// order by 中的位置和 select 中的别名引用对应的输出字段, 与 select 中相同的表达式直接引用其结果,
+// 其他表达式作为隐藏列加入 project 或 aggregate 中计算, 排序后再使用 project 去除隐藏列
+type ResolveSortReferences struct{}
+
+func (ResolveSortReferences) Apply(plan Plan) Plan {
+	return Transform(plan, func(p Plan) Plan {
+		sort, ok := p.(*Sort)
+		if !ok {
+			return p
+		}
+		schema := sort.Child.GetSchema()
+		var selectList []expression.Expression
+		switch t := sort.Child.(type) {
+		case *Project:
+			selectList = t.ProjectList
+		case *Aggregate:
+			selectList = t.AggregateExprs
+		}
+		var outputs []expression.Expression
+		for _, field := range schema {
+			outputs = append(outputs, &expression.Attribute{Name: field.Name})
+		}
+		hiddenCount := 0
+		// 返回 select 中相同表达式对应的输出字段
+		reference := func(e expression.Expression) (string, bool) {
+			for i, expr := range selectList {
+				if alias, ok := expr.(*expression.Alias); ok {
+					expr = alias.Child
+				}
+				if i < len(schema) && expr.Print() == e.Print() {
+					return schema[i].Name, true
+				}
+			}
+			return "", false
+		}
+		for i, order := range sort.Order {
+			if n, ok := ordinal(order.Expr); ok {
+				if n < 1 || n > len(schema) {
+					panic(fmt.Sprintf("order by position %d is not in select list", n))
+				}
+				sort.Order[i].Expr = &expression.Attribute{Name: schema[n-1].Name}
+				continue
+			}
+			// 聚合函数需要在 aggregate 中计算, 即使没有引用字段
+			isAggregate := hasAggregate([]expression.Expression{order.Expr})
+			if !isAggregate && resolvable(order.Expr, schema) {
+				continue
+			}
+			if name, ok := reference(order.Expr); ok {
+				sort.Order[i].Expr = &expression.Attribute{Name: name}
+				continue
+			}
+			name := "sort_$" + strconv.Itoa(hiddenCount+1)
+			hidden := &expression.Alias{Child: order.Expr, Name: name}
+			switch t := sort.Child.(type) {
+			case *Project:
+				t.ProjectList = append(t.ProjectList, hidden)
+				t.schemaCache = nil
+			case *Aggregate:
+				t.AggregateExprs = append(t.AggregateExprs, hidden)
+				t.schemaCache = nil
+			default:
+				// 由 CheckSchema 报告找不到字段
+				continue
+			}
+			hiddenCount += 1
+			sort.Order[i].Expr = &expression.Attribute{Name: name}
+		}
+		if hiddenCount == 0 {
+			return p
+		}
+		return &Project{
+			ProjectList: outputs,
+			Child:       sort,
+		}
+	})
+}
+
+// 整数常量表示 select 中的位置, 从 1 开始
+func ordinal(e expression.Expression) (int, bool) {
+	if lit, ok := e.(*expression.Literal); ok && lit.Type == rows.Int && !lit.IsNull {
+		return int(lit.Value.(int64)), true
+	}
+	return 0, false
+}
+
 // 只能在 group 中使用聚合函数
 type CheckAggregateUse struct {}
 
@@ -93,6 +547,22 @@ func (CheckSchema) Apply(plan Plan) Plan {
 		if filter, ok := p.(*Filter); ok {
 			checkExpr(filter.Condition, filter.Child.GetSchema())
 		}
+		if sort, ok := p.(*Sort); ok {
+			for _, order := range sort.Order {
+				checkExpr(order.Expr, sort.Child.GetSchema())
+			}
+		}
+		if join, ok := p.(*Join); ok && join.Condition != nil {
+			checkExpr(join.Condition, join.joinSchema())
+		}
+		if window, ok := p.(*Window); ok {
+			option := window.Child.GetSchema()
+			for _, e := range window.WindowExprs {
+				for _, child := range e.(*expression.Alias).Child.GetChildren() {
+					checkExpr(*child, option)
+				}
+			}
+		}
 		if agg, ok := p.(*Aggregate); ok {
 			option := agg.Child.GetSchema()
 			for _, expr := range append(agg.GroupExprs, agg.AggregateExprs...) {
@@ -126,10 +596,18 @@ func (p ProxyExprInAggregate) Apply(plan Plan) Plan {
 	return Transform(plan, func(p Plan) Plan {
 		if agg, ok := p.(*Aggregate); ok {
 			groupSchema := agg.GetGroupSchema()
+			var proxy func(expr expression.Expression) expression.Expression
+			proxy = func(expr expression.Expression) expression.Expression {
+				// 聚合函数的参数基于组内原始数据求值, 不能被当作分组 key 代理
+				if _, ok := expr.(expression.AggFunction); !ok {
+					for _, child := range expr.GetChildren() {
+						*child = proxy(*child)
+					}
+				}
+				return &expression.ExprProxy{Expr: expr, GroupSchema: groupSchema}
+			}
 			for i, aggExpr := range agg.AggregateExprs {
-				agg.AggregateExprs[i] = expression.Transform(aggExpr, func(expr expression.Expression) expression.Expression {
-					return &expression.ExprProxy{Expr: expr, GroupSchema: groupSchema}
-				})
+				agg.AggregateExprs[i] = proxy(aggExpr)
 			}
 		}
 		return p
@@ -1,209 +1,896 @@
 package plan
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"sql-engine/config"
 	"sql-engine/expression"
 	"sql-engine/rows"
+	"sql-engine/sqlerr"
 	"strings"
 	"sync"
 )
 
-func (r *Relation) Execute() rows.Dataset {
+// 拉取 plan 的全部数据, 表达式求值时的 panic 转为 ExecutionError
+func Collect(ctx context.Context, p Plan) (dataset rows.Dataset, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = sqlerr.FromPanic(r, sqlerr.NewExecutionError)
+		}
+	}()
+	if err = p.Open(ctx); err != nil {
+		p.Close()
+		return
+	}
+	defer p.Close()
 	var result []rows.Row
-	for _, data := range r.DataSource.Execute(r.PushDownPredicate) {
-		row := rows.New(data)
+	width := len(p.GetSchema())
+	for {
+		row, err := p.Next()
+		if err != nil {
+			return rows.Dataset{}, err
+		}
+		if row == nil {
+			break
+		}
+		if err = reserveRow(ctx, row, width); err != nil {
+			return rows.Dataset{}, err
+		}
 		result = append(result, row)
 	}
 	return rows.Dataset{
 		Data:   result,
-		Schema: r.DataSource.GetSchema(),
-	}
+		Schema: p.GetSchema(),
+	}, nil
 }
 
-func (s *Subquery) Execute() rows.Dataset {
-	return rows.Dataset{
-		Data:   s.Child.Execute().Data,
-		Schema: s.GetSchema(),
+func (r *Relation) Open(ctx context.Context) error {
+	r.ctx = ctx
+	return r.DataSource.Open(ctx, r.PushDownPredicate)
+}
+
+// 所有数据都从 relation 读取, 在这里检查查询是否已经取消
+func (r *Relation) Next() (rows.Row, error) {
+	if err := checkContext(r.ctx); err != nil {
+		return nil, err
 	}
+	data, err := r.DataSource.Next()
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return rows.New(data), nil
 }
 
-func (u *Union) Execute() rows.Dataset {
-	var result []rows.Row
-	type option struct {
-		err  error
-		data []rows.Row
-	}
-
-	ch := make(chan option, len(u.Children))
-	jobParallel := make(chan int, 5)
-	wg := sync.WaitGroup{}
-	wg.Add(len(u.Children))
-	for _, child := range u.Children {
-		jobParallel <- 0
-		go func(plan Plan) {
-			defer func() {
-				if err := recover(); err != nil {
-					ch <- option{err: errors.New(fmt.Sprintf("union all execute err: %v", err))}
+func (r *Relation) Close() {
+	r.DataSource.Close()
+}
+
+func (s *Subquery) Open(ctx context.Context) error {
+	return s.Child.Open(ctx)
+}
+
+func (s *Subquery) Next() (rows.Row, error) {
+	return s.Child.Next()
+}
+
+func (s *Subquery) Close() {
+	s.Child.Close()
+}
+
+type unionOption struct {
+	err error
+	row rows.Row
+}
+
+// 每个子查询在单独的 goroutine 中执行, 数据通过 channel 汇总
+func (u *Union) Open(ctx context.Context) error {
+	ch := make(chan unionOption)
+	done := make(chan struct{})
+	u.ctx, u.rowCh, u.done = ctx, ch, done
+	parallelism := u.Parallelism
+	if parallelism <= 0 {
+		parallelism = config.Default().UnionParallelism
+	}
+	go func() {
+		jobParallel := make(chan int, parallelism)
+		wg := sync.WaitGroup{}
+	dispatch:
+		for _, child := range u.Children {
+			select {
+			case jobParallel <- 0:
+			case <-done:
+				break dispatch
+			case <-ctx.Done():
+				break dispatch
+			}
+			wg.Add(1)
+			go func(plan Plan) {
+				send := func(opt unionOption) bool {
+					select {
+					case ch <- opt:
+						return true
+					case <-done:
+						return false
+					}
 				}
-				<-jobParallel
-				wg.Done()
-			}()
-			ch <- option{data: plan.Execute().Data}
-		}(child)
-	}
-	wg.Wait()
-	close(ch)
-	close(jobParallel)
-	for i := 0; i < len(u.Children); i++ {
-		if opt := <- ch; opt.err != nil {
-			panic(opt.err)
-		} else {
-			result = append(result, opt.data...)
+				defer func() {
+					if r := recover(); r != nil {
+						send(unionOption{err: sqlerr.FromPanic(r, sqlerr.NewExecutionError)})
+					}
+					<-jobParallel
+					wg.Done()
+				}()
+				if err := plan.Open(ctx); err != nil {
+					plan.Close()
+					send(unionOption{err: err})
+					return
+				}
+				defer plan.Close()
+				for {
+					row, err := plan.Next()
+					if err != nil {
+						send(unionOption{err: err})
+						return
+					}
+					if row == nil || !send(unionOption{row: row}) {
+						return
+					}
+				}
+			}(child)
+		}
+		wg.Wait()
+		close(ch)
+	}()
+	return nil
+}
+
+func (i *Intersect) Open(ctx context.Context) error {
+	return i.open(ctx, i.Left, i.Right)
+}
+
+func (i *Intersect) Next() (rows.Row, error) {
+	return i.next(i.Left, func(key string) bool {
+		if i.counts[key] == 0 {
+			return false
+		}
+		if i.All {
+			i.counts[key] -= 1
+			return true
 		}
+		return !i.seen[key]
+	})
+}
+
+func (i *Intersect) Close() {
+	i.close(i.Left)
+}
+
+func (e *Except) Open(ctx context.Context) error {
+	return e.open(ctx, e.Left, e.Right)
+}
+
+func (e *Except) Next() (rows.Row, error) {
+	return e.next(e.Left, func(key string) bool {
+		if e.All {
+			if e.counts[key] > 0 {
+				e.counts[key] -= 1
+				return false
+			}
+			return true
+		}
+		return e.counts[key] == 0 && !e.seen[key]
+	})
+}
+
+func (e *Except) Close() {
+	e.close(e.Left)
+}
+
+func (s *setOperation) open(ctx context.Context, left, right Plan) error {
+	dataset, err := Collect(ctx, right)
+	if err != nil {
+		return err
 	}
-	return rows.Dataset{
-		Data:   result,
-		Schema: u.GetSchema(),
+	s.ctx = ctx
+	s.width = len(dataset.Schema)
+	s.counts = make(map[string]int)
+	s.seen = make(map[string]bool)
+	for _, row := range dataset.Data {
+		s.counts[rowKey(row, s.width)] += 1
+	}
+	return left.Open(ctx)
+}
+
+// 返回左侧下一行 keep 为 true 的数据, 输出的行记录在 seen 中
+func (s *setOperation) next(left Plan, keep func(key string) bool) (rows.Row, error) {
+	for {
+		row, err := left.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		key := rowKey(row, s.width)
+		if !keep(key) {
+			continue
+		}
+		if !s.seen[key] {
+			if err = reserveRow(s.ctx, row, s.width); err != nil {
+				return nil, err
+			}
+			s.seen[key] = true
+		}
+		return row, nil
+	}
+}
+
+func (s *setOperation) close(left Plan) {
+	s.counts, s.seen = nil, nil
+	left.Close()
+}
+
+func (u *Union) Next() (rows.Row, error) {
+	select {
+	case opt, ok := <-u.rowCh:
+		if !ok {
+			// 取消时可能还没有调度全部子查询
+			return nil, checkContext(u.ctx)
+		}
+		return opt.row, opt.err
+	case <-u.ctx.Done():
+		return nil, checkContext(u.ctx)
 	}
 }
 
-func (p *Project) Execute() rows.Dataset {
+// 通知所有子查询停止, 并等待其退出
+func (u *Union) Close() {
+	if u.done == nil {
+		return
+	}
+	close(u.done)
+	for range u.rowCh {
+	}
+	u.rowCh, u.done = nil, nil
+}
+
+func (p *Project) Open(ctx context.Context) error {
+	return p.Child.Open(ctx)
+}
+
+func (p *Project) Next() (rows.Row, error) {
+	row, err := p.Child.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
 	subSchema := p.Child.GetSchema()
-	handle := func(row rows.Row) rows.Row {
-		var data []interface{}
-		for _, expr := range p.ProjectList {
-			// 区分 * 和其他情况
-			if star, ok := expr.(*expression.Star); ok {
-				for i, field := range subSchema {
-					// 如果不是单独的 '*' 那么就要匹配 table 名
-					if star.Table == "" || strings.HasPrefix(field.Name, star.Table+".") {
-						data = append(data, row.IndexOf(i))
-					}
+	var data []interface{}
+	for _, expr := range p.ProjectList {
+		// 区分 * 和其他情况
+		if star, ok := expr.(*expression.Star); ok {
+			for i, field := range subSchema {
+				// 如果不是单独的 '*' 那么就要匹配 table 名
+				if star.Table == "" || strings.HasPrefix(field.Name, star.Table+".") {
+					data = append(data, row.IndexOf(i))
 				}
-			} else {
-				r := expr.Eval(row)
-				data = append(data, r)
 			}
+		} else {
+			r := expr.Eval(row)
+			data = append(data, r)
 		}
-		r := rows.New(data)
-		return r
 	}
-	var result []rows.Row
-	for _, row := range p.Child.Execute().Data {
-		result = append(result, handle(row))
+	return rows.New(data), nil
+}
+
+func (p *Project) Close() {
+	p.Child.Close()
+}
+
+func (f *Filter) Open(ctx context.Context) error {
+	return f.Child.Open(ctx)
+}
+
+func (f *Filter) Next() (rows.Row, error) {
+	for {
+		row, err := f.Child.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		if ok, err := evalCondition(f.Condition, row); err != nil || ok {
+			return row, err
+		}
 	}
-	return rows.Dataset{
-		Data:   result,
-		Schema: p.GetSchema(),
+}
+
+func (f *Filter) Close() {
+	f.Child.Close()
+}
+
+type group struct {
+	key          string                   // rowKey 的 hash 值
+	rowKey       rows.Row                 // 这个分组的 key, 也就是 group by 后表达式的 row
+	accumulators []expression.Accumulator // 与 aggregate 中的聚合函数一一对应
+}
+
+func newGroup(key string, rowKey rows.Row, aggFunctions []expression.AggFunction) *group {
+	g := &group{key: key, rowKey: rowKey}
+	for _, f := range aggFunctions {
+		g.accumulators = append(g.accumulators, f.NewAccumulator())
 	}
+	return g
 }
 
-func (f *Filter) Execute() rows.Dataset {
-	var result []rows.Row
-	for _, row := range f.Child.Execute().Data {
-		value := f.Condition.Eval(row)
-		if b, ok := value.(*bool); !ok {
-			panic(fmt.Sprintf("expect bool type, but got %t", value))
-		} else if b != nil && *b {
-			result = append(result, row)
+func (g *group) update(row rows.Row) {
+	for _, acc := range g.accumulators {
+		acc.Update(row)
+	}
+}
+
+// 基于 hash 的分组, 每一行只更新所属分组的聚合状态, 不保留原始数据.
+// 有 Partitions 时依次聚合每个分区, 再合并相同分组的状态
+func (a *Aggregate) Open(ctx context.Context) error {
+	a.aggFunctions = a.collectAggFunctions()
+	a.groups = nil
+	inputs := a.Partitions
+	if len(inputs) == 0 {
+		inputs = []Plan{a.Child}
+	}
+	var partitions [][]*group
+	for _, input := range inputs {
+		groups, err := a.aggregate(ctx, input)
+		if err != nil {
+			return err
 		}
+		partitions = append(partitions, groups)
 	}
-	return rows.Dataset{
-		Data:   result,
-		Schema: f.GetSchema(),
+	a.groups = mergeGroups(partitions)
+	// 兼容 select count(1) from xx 的情况，没有 group by 时即使没有数据也返回一行
+	if len(a.groups) == 0 && a.isGlobal() {
+		var keys []interface{}
+		for _, expr := range a.GroupExprs {
+			keys = append(keys, expr.Eval(nil))
+		}
+		key, _ := hashKey(keys)
+		a.groups = append(a.groups, newGroup(key, rows.New(keys), a.aggFunctions))
 	}
+	return nil
 }
 
-type group struct {
-	groupSchema []rows.StructField // group by 后的表达式的 schema
-	groupExprs  []expression.Expression // group by 后的表达式
-	rowKey      *rows.Row // 这个分组的 key, 也就是 group by 后表达式的 row
-	data        []rows.Row // 这个分组下的所有 row
+// 聚合 child 的所有数据, 分组按首次出现的顺序返回
+func (a *Aggregate) aggregate(ctx context.Context, child Plan) ([]*group, error) {
+	var result []*group
+	groups := make(map[string]*group)
+	if err := child.Open(ctx); err != nil {
+		child.Close()
+		return nil, err
+	}
+	defer child.Close()
+	for {
+		row, err := child.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return result, nil
+		}
+		var keys []interface{}
+		for _, expr := range a.GroupExprs {
+			keys = append(keys, expr.Eval(row))
+		}
+		key, _ := hashKey(keys)
+		g, ok := groups[key]
+		if !ok {
+			if err = reserveRow(ctx, rows.New(keys), len(keys)); err != nil {
+				return nil, err
+			}
+			g = newGroup(key, rows.New(keys), a.aggFunctions)
+			groups[key] = g
+			result = append(result, g)
+		}
+		g.update(row)
+	}
+}
+
+// 按顺序合并各分区的分组, 相同分组的聚合状态通过 Accumulator.Merge 合并
+func mergeGroups(partitions [][]*group) []*group {
+	if len(partitions) == 1 {
+		return partitions[0]
+	}
+	var result []*group
+	merged := make(map[string]*group)
+	for _, groups := range partitions {
+		for _, g := range groups {
+			target, ok := merged[g.key]
+			if !ok {
+				merged[g.key] = g
+				result = append(result, g)
+				continue
+			}
+			for i, acc := range target.accumulators {
+				acc.Merge(g.accumulators[i])
+			}
+		}
+	}
+	return result
 }
 
-func newGroup(exprs []expression.Expression, schema []rows.StructField) *group {
-	return &group{
-		groupExprs:  exprs,
-		groupSchema: schema,
+func (a *Aggregate) Next() (rows.Row, error) {
+	if len(a.groups) == 0 {
+		return nil, nil
 	}
+	g := a.groups[0]
+	a.groups = a.groups[1:]
+	for i, f := range a.aggFunctions {
+		f.SetResult(g.accumulators[i].Result())
+	}
+	var rowData []interface{}
+	for _, expr := range a.AggregateExprs {
+		rowData = append(rowData, expr.Eval(g.rowKey))
+	}
+	return rows.New(rowData), nil
 }
 
-func (g *group) add(row rows.Row) {
-	g.data = append(g.data, row)
-	if g.rowKey != nil {
-		return
+func (a *Aggregate) Close() {
+	a.groups = nil
+}
+
+// 按顺序找出 select 中的所有聚合函数
+func (a *Aggregate) collectAggFunctions() []expression.AggFunction {
+	var result []expression.AggFunction
+	for _, expr := range a.AggregateExprs {
+		expression.Transform(expr, func(e expression.Expression) expression.Expression {
+			target := e
+			if proxy, ok := e.(*expression.ExprProxy); ok {
+				target = proxy.Expr
+			}
+			if f, ok := target.(expression.AggFunction); ok {
+				result = append(result, f)
+			}
+			return e
+		})
 	}
-	// 计算 group by key
-	var data []interface{}
-	for _, expr := range g.groupExprs {
-		v := expr.Eval(row)
-		data = append(data, v)
+	return result
+}
+
+// 分组表达式都为常量, 也就是没有 group by 的聚合
+func (a *Aggregate) isGlobal() bool {
+	for _, expr := range a.GroupExprs {
+		if _, ok := expr.(*expression.Literal); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Sort) Open(ctx context.Context) error {
+	dataset, err := Collect(ctx, s.Child)
+	if err != nil {
+		return err
 	}
-	rowKey := rows.New(data)
-	g.rowKey = &rowKey
+	sorter := newSorter(s.Order)
+	s.result = sorter.sort(dataset).Data
+	return nil
 }
 
-// 基于排序的分组
-func sortBaseGroups(dataset rows.Dataset, groupExprs []expression.Expression, groupSchema []rows.StructField) []*group {
-	var sortOrder []SortOrder
-	for _, expr := range groupExprs {
-		sortOrder = append(sortOrder, SortOrder{Expr: expr})
+func (s *Sort) Next() (rows.Row, error) {
+	if len(s.result) == 0 {
+		return nil, nil
 	}
-	sorter := newSorter(sortOrder)
-	dataset = sorter.sort(dataset)
-	gsorter := sorter.(*genericSorter)
-	// 从前往后读, group by key 相等的为一组
-	var groupData []*group
-	groupData = append(groupData, newGroup(groupExprs, groupSchema))
-	// 兼容 select count(1) from xx 的情况，没有 group by 可能 from 后条数为 0
-	if len(dataset.Data) != 0 {
-		groupData[0].add(dataset.Data[0])
+	row := s.result[0]
+	s.result = s.result[1:]
+	return row, nil
+}
+
+func (s *Sort) Close() {
+	s.result = nil
+}
+
+func (l *Limit) Open(ctx context.Context) error {
+	l.emitted = 0
+	return l.Child.Open(ctx)
+}
+
+// 先跳过 Offset 行, 达到数量后不再从子节点拉取数据
+func (l *Limit) Next() (rows.Row, error) {
+	for ; l.emitted < l.Offset; l.emitted++ {
+		if row, err := l.Child.Next(); row == nil || err != nil {
+			return nil, err
+		}
+	}
+	if l.emitted >= l.Offset+l.Count {
+		return nil, nil
 	}
-	for i := 1; i < len(dataset.Data); i++ {
-		if gsorter.Less(i-1, i) {
-			// 前一个不比当前小，新增一个组
-			groupData = append(groupData, newGroup(groupExprs, groupSchema))
+	row, err := l.Child.Next()
+	if row != nil {
+		l.emitted += 1
+	}
+	return row, err
+}
+
+func (l *Limit) Close() {
+	l.Child.Close()
+}
+
+func (d *Distinct) Open(ctx context.Context) error {
+	d.ctx = ctx
+	d.seen = make(map[string]bool)
+	return d.Child.Open(ctx)
+}
+
+// 保留每组重复数据中的第一行
+func (d *Distinct) Next() (rows.Row, error) {
+	width := len(d.GetSchema())
+	for {
+		row, err := d.Child.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		if key := rowKey(row, width); !d.seen[key] {
+			if err = reserveRow(d.ctx, row, width); err != nil {
+				return nil, err
+			}
+			d.seen[key] = true
+			return row, nil
 		}
-		groupData[len(groupData)-1].add(dataset.Data[i])
 	}
-	return groupData
 }
 
-func (a *Aggregate) Execute() rows.Dataset {
-	groupData := sortBaseGroups(a.Child.Execute(), a.GroupExprs, a.GetGroupSchema())
-	// 对每一组求值
-	var result []rows.Row
-	for _, g := range groupData {
-		var rowData []interface{}
-		for _, expr := range a.AggregateExprs {
-			expr.(*expression.ExprProxy).RowGroup = g.data
-			// 没有 group by 的情况 row key 可能为 nil
-			if g.rowKey != nil {
-				rowData = append(rowData, expr.Eval(*g.rowKey))
+func (d *Distinct) Close() {
+	d.seen = nil
+	d.Child.Close()
+}
+
+// 按分区和排序排列子节点的数据, 逐个分区计算窗口函数
+func (w *Window) Open(ctx context.Context) error {
+	dataset, err := Collect(ctx, w.Child)
+	if err != nil {
+		return err
+	}
+	schema := w.GetSchema()
+	width := len(dataset.Schema)
+	var order []SortOrder
+	for _, e := range w.PartitionBy {
+		order = append(order, SortOrder{Expr: e})
+	}
+	data := newSorter(append(order, w.Order...)).sort(dataset).Data
+	w.result = make([]rows.Row, 0, len(data))
+	for start := 0; start < len(data); {
+		end := start + 1
+		key := w.partitionKey(data[start])
+		for end < len(data) && w.partitionKey(data[end]) == key {
+			end += 1
+		}
+		partition := data[start:end]
+		values := w.evalPartition(partition)
+		for i, row := range partition {
+			newRow := make([]interface{}, 0, len(schema))
+			for j := 0; j < width; j++ {
+				newRow = append(newRow, row.IndexOf(j))
+			}
+			for j, v := range values {
+				if v[i] == nil {
+					v[i] = expression.NullByType(schema[width+j].DataType)
+				}
+				newRow = append(newRow, v[i])
+			}
+			w.result = append(w.result, rows.New(newRow))
+		}
+		start = end
+	}
+	return nil
+}
+
+func (w *Window) Next() (rows.Row, error) {
+	if len(w.result) == 0 {
+		return nil, nil
+	}
+	row := w.result[0]
+	w.result = w.result[1:]
+	return row, nil
+}
+
+func (w *Window) Close() {
+	w.result = nil
+}
+
+// 子查询只执行一次, 结果写入引用它的表达式
+func (s *ScalarSubqueries) Open(ctx context.Context) error {
+	for i, query := range s.Subqueries {
+		result, err := Collect(ctx, query)
+		if err != nil {
+			return err
+		}
+		if len(result.Data) > 1 {
+			return &sqlerr.ExecutionError{Msg: fmt.Sprintf("scalar subquery returns %d rows, expect at most one", len(result.Data))}
+		}
+		var value interface{}
+		if len(result.Data) == 1 {
+			value = result.Data[0].IndexOf(0)
+		}
+		// 没有结果或结果为不带类型的 nil 时, 使用对应类型的 null
+		if value == nil {
+			value = expression.NullByType(result.Schema[0].DataType)
+		}
+		s.exprs[i].SetResult(value)
+	}
+	return s.Child.Open(ctx)
+}
+
+func (s *ScalarSubqueries) Next() (rows.Row, error) {
+	return s.Child.Next()
+}
+
+func (s *ScalarSubqueries) Close() {
+	s.Child.Close()
+}
+
+// 右侧数据全部读入内存, 左侧逐行读取
+func (j *Join) Open(ctx context.Context) error {
+	right, err := Collect(ctx, j.Right)
+	if err != nil {
+		return err
+	}
+	j.right = right.Data
+	j.leftNull = nullRow(j.Left.GetSchema())
+	j.rightNull = nullRow(j.Right.GetSchema())
+	j.leftWidth, j.rightWidth = len(j.Left.GetSchema()), len(j.Right.GetSchema())
+	// 有等值条件时使用右侧构建 hash 表, 否则每一行都需要与右侧所有行比较
+	j.buildTable = nil
+	j.rightHasNull = false
+	if len(j.LeftKeys) != 0 {
+		j.buildTable = make(map[string][]int)
+		for i, r := range j.right {
+			if key, hasNull := j.evalKey(j.RightKeys, joinRow(j.leftNull, r, j.leftWidth, j.rightWidth)); !hasNull {
+				j.buildTable[key] = append(j.buildTable[key], i)
 			} else {
-				rowData = append(rowData, expr.Eval(nil))
+				j.rightHasNull = true
 			}
 		}
-		r := rows.New(rowData)
-		result = append(result, r)
 	}
-	return rows.Dataset{
-		Data:   result,
-		Schema: a.GetSchema(),
+	// 记录右侧已匹配的行，用于 right join 和 full join 补齐
+	j.rightMatched = make([]bool, len(j.right))
+	j.pending = nil
+	j.leftDone = false
+	return j.Left.Open(ctx)
+}
+
+func (j *Join) Next() (rows.Row, error) {
+	for len(j.pending) == 0 {
+		if j.leftDone {
+			return nil, nil
+		}
+		l, err := j.Left.Next()
+		if err != nil {
+			return nil, err
+		}
+		if l != nil {
+			if j.pending, err = j.probe(l); err != nil {
+				return nil, err
+			}
+		} else {
+			j.leftDone = true
+			j.pending = j.unmatchedRight()
+		}
 	}
+	row := j.pending[0]
+	j.pending = j.pending[1:]
+	return row, nil
 }
 
-func (s *Sort) Execute() rows.Dataset {
-	sorter := newSorter(s.Order)
-	return sorter.sort(s.Child.Execute())
+func (j *Join) Close() {
+	j.right, j.buildTable, j.rightMatched, j.pending = nil, nil, nil, nil
+	j.Left.Close()
 }
 
-func (l *Limit) Execute() rows.Dataset {
-	dataset := l.Child.Execute()
-	if l.Count >= len(dataset.Data) {
-		return dataset
+// 返回左侧一行与右侧匹配的所有结果
+func (j *Join) probe(l rows.Row) ([]rows.Row, error) {
+	if j.JoinType == LeftSemiJoin || j.JoinType == LeftAntiJoin {
+		matched, err := j.exists(l)
+		if err != nil {
+			return nil, err
+		}
+		if matched == (j.JoinType == LeftSemiJoin) {
+			return []rows.Row{l}, nil
+		}
+		return nil, nil
+	}
+	var result []rows.Row
+	if j.buildTable != nil {
+		// null 不与任何值相等，不需要探测
+		key, hasNull := j.evalKey(j.LeftKeys, joinRow(l, j.rightNull, j.leftWidth, j.rightWidth))
+		if !hasNull {
+			for _, i := range j.buildTable[key] {
+				row := joinRow(l, j.right[i], j.leftWidth, j.rightWidth)
+				if ok, err := evalCondition(j.Residual, row); err != nil {
+					return nil, err
+				} else if ok {
+					j.rightMatched[i] = true
+					result = append(result, row)
+				}
+			}
+		}
+	} else {
+		for i, r := range j.right {
+			row := joinRow(l, r, j.leftWidth, j.rightWidth)
+			if ok, err := evalCondition(j.Condition, row); err != nil {
+				return nil, err
+			} else if ok {
+				j.rightMatched[i] = true
+				result = append(result, row)
+			}
+		}
+	}
+	if len(result) == 0 && (j.JoinType == LeftOuterJoin || j.JoinType == FullOuterJoin) {
+		result = append(result, joinRow(l, j.rightNull, j.leftWidth, j.rightWidth))
+	}
+	return result, nil
+}
+
+// semi join 和 anti join 只需要判断左侧一行在右侧是否存在匹配
+func (j *Join) exists(l rows.Row) (bool, error) {
+	if j.buildTable != nil {
+		key, hasNull := j.evalKey(j.LeftKeys, joinRow(l, j.rightNull, j.leftWidth, j.rightWidth))
+		// x not in (...) 在右侧不为空时, x 为 null 或右侧含有 null 的结果都是 null
+		if j.NullAware && len(j.right) != 0 && (hasNull || j.rightHasNull) {
+			return true, nil
+		}
+		if hasNull {
+			return false, nil
+		}
+		for _, i := range j.buildTable[key] {
+			if ok, err := evalCondition(j.Residual, joinRow(l, j.right[i], j.leftWidth, j.rightWidth)); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	for _, r := range j.right {
+		row := joinRow(l, r, j.leftWidth, j.rightWidth)
+		if j.NullAware {
+			if b, ok := j.Condition.Eval(row).(*bool); ok && b == nil {
+				return true, nil
+			}
+		}
+		if ok, err := evalCondition(j.Condition, row); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (j *Join) unmatchedRight() []rows.Row {
+	if j.JoinType != RightOuterJoin && j.JoinType != FullOuterJoin {
+		return nil
+	}
+	var result []rows.Row
+	for i, r := range j.right {
+		if !j.rightMatched[i] {
+			result = append(result, joinRow(j.leftNull, r, j.leftWidth, j.rightWidth))
+		}
+	}
+	return result
+}
+
+func (j *Join) evalKey(keys []expression.Expression, row rows.Row) (string, bool) {
+	var values []interface{}
+	for _, key := range keys {
+		values = append(values, key.Eval(row))
+	}
+	return hashKey(values)
+}
+
+func (r *RecursiveCte) Open(ctx context.Context) error {
+	r.ctx = ctx
+	r.seen = make(map[string]bool)
+	r.depth = 0
+	anchor, err := Collect(ctx, r.Anchor)
+	if err != nil {
+		return err
+	}
+	r.work = r.filterSeen(anchor.Data)
+	r.pending = r.work
+	return nil
+}
+
+func (r *RecursiveCte) Next() (rows.Row, error) {
+	for len(r.pending) == 0 {
+		if len(r.work) == 0 {
+			return nil, nil
+		}
+		r.depth += 1
+		result, err := Collect(r.ctx, r.Recursive)
+		if err != nil {
+			return nil, err
+		}
+		r.work = r.filterSeen(result.Data)
+		// 超过最大次数的一轮仍有新的行时才报错, 没有新行说明递归已经正常结束
+		if r.depth > r.MaxDepth && len(r.work) > 0 {
+			return nil, &sqlerr.ExecutionError{
+				Msg: fmt.Sprintf("recursion of '%s' exceeds max depth %d, see recursion.depth", r.Name, r.MaxDepth),
+			}
+		}
+		r.pending = r.work
+	}
+	row := r.pending[0]
+	r.pending = r.pending[1:]
+	return row, nil
+}
+
+func (r *RecursiveCte) Close() {
+	r.work, r.pending, r.seen = nil, nil, nil
+}
+
+// distinct 时去除之前轮次已经输出过的行和本轮中的重复, union all 时保留所有行, 由最大迭代次数终止
+func (r *RecursiveCte) filterSeen(data []rows.Row) []rows.Row {
+	if !r.Distinct {
+		return data
+	}
+	width := len(r.GetSchema())
+	var result []rows.Row
+	for _, row := range data {
+		key := rowKey(row, width)
+		if r.seen[key] {
+			continue
+		}
+		r.seen[key] = true
+		result = append(result, row)
+	}
+	return result
+}
+
+func (w *WorkTable) Open(context.Context) error {
+	w.rows = w.Cte.work
+	return nil
+}
+
+func (w *WorkTable) Next() (rows.Row, error) {
+	if len(w.rows) == 0 {
+		return nil, nil
+	}
+	row := w.rows[0]
+	w.rows = w.rows[1:]
+	return row, nil
+}
+
+func (w *WorkTable) Close() {
+	w.rows = nil
+}
+
+// 查询被取消或超时时返回 ExecutionError, 可以通过 errors.Is 判断原因
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &sqlerr.ExecutionError{Msg: "query canceled: " + err.Error(), Err: err}
+	}
+	return nil
+}
+
+// 条件为空时视为 true
+func evalCondition(condition expression.Expression, row rows.Row) (bool, error) {
+	if condition == nil {
+		return true, nil
+	}
+	value := condition.Eval(row)
+	if b, ok := value.(*bool); !ok {
+		return false, &sqlerr.ExecutionError{Msg: fmt.Sprintf("expect bool type, but got %T", value)}
+	} else {
+		return b != nil && *b, nil
+	}
+}
+
+// 将左右两行拼接为一行
+func joinRow(left, right rows.Row, leftWidth, rightWidth int) rows.Row {
+	data := make([]interface{}, 0, leftWidth+rightWidth)
+	for i := 0; i < leftWidth; i++ {
+		data = append(data, left.IndexOf(i))
+	}
+	for i := 0; i < rightWidth; i++ {
+		data = append(data, right.IndexOf(i))
+	}
+	return rows.New(data)
+}
+
+// 生成一行全为 null 的数据，outer join 时用于补齐另一侧
+func nullRow(schema []rows.StructField) rows.Row {
+	var data []interface{}
+	for _, field := range schema {
+		data = append(data, expression.NullByType(field.DataType))
 	}
-	dataset.Data = dataset.Data[:l.Count]
-	return dataset
+	return rows.New(data)
 }
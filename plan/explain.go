@@ -0,0 +1,57 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"sql-engine/rows"
+	"time"
+)
+
+// explain analyze 时包装每个节点, 记录输出行数和耗时. 耗时包含子节点
+type analyzed struct {
+	Child   Plan
+	rows    int64
+	elapsed time.Duration
+}
+
+// 为 plan 的每个节点附加统计, 执行后通过 AnalyzedTreeLines 输出
+func Instrument(p Plan) Plan {
+	return Transform(p, func(p Plan) Plan {
+		return &analyzed{Child: p}
+	})
+}
+
+func (a *analyzed) Open(ctx context.Context) error {
+	start := time.Now()
+	defer func() { a.elapsed += time.Since(start) }()
+	return a.Child.Open(ctx)
+}
+
+func (a *analyzed) Next() (rows.Row, error) {
+	start := time.Now()
+	defer func() { a.elapsed += time.Since(start) }()
+	row, err := a.Child.Next()
+	if row != nil {
+		a.rows += 1
+	}
+	return row, err
+}
+
+func (a *analyzed) Close() {
+	start := time.Now()
+	defer func() { a.elapsed += time.Since(start) }()
+	a.Child.Close()
+}
+
+func (a *analyzed) Describe() string {
+	return fmt.Sprintf("%s (rows = %d, time = %s)", a.Child.Describe(), a.rows, a.elapsed.Round(time.Microsecond))
+}
+
+func (a *analyzed) GetSchema() []rows.StructField {
+	return a.Child.GetSchema()
+}
+
+// 子节点已经被包装, 跳过自身直接返回被包装节点的子节点
+func (a *analyzed) GetChildren() []*Plan {
+	return a.Child.GetChildren()
+}
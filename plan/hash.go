@@ -0,0 +1,53 @@
+package plan
+
+import (
+	"math"
+	"sql-engine/rows"
+	"sql-engine/util/pointer"
+	"strconv"
+	"strings"
+)
+
+// 将一组值编码为可作为 map key 的字符串, 数值类型统一编码, 保证 1 与 1.0 得到相同的 key.
+// 第二个返回值表示其中是否含有 null
+func hashKey(values []interface{}) (string, bool) {
+	sb := strings.Builder{}
+	hasNull := false
+	for _, value := range values {
+		if pointer.IsNil(value) {
+			hasNull = true
+			sb.WriteString("n;")
+			continue
+		}
+		switch v := value.(type) {
+		case *string:
+			// 写入长度避免字符串中的分隔符造成冲突
+			sb.WriteString("s" + strconv.Itoa(len(*v)) + ":" + *v)
+		case *int64:
+			sb.WriteString("d" + strconv.FormatInt(*v, 10) + ";")
+		case *float64:
+			if *v == math.Trunc(*v) && math.Abs(*v) < math.MaxInt64 {
+				sb.WriteString("d" + strconv.FormatInt(int64(*v), 10) + ";")
+			} else {
+				sb.WriteString("f" + strconv.FormatFloat(*v, 'g', -1, 64) + ";")
+			}
+		case *bool:
+			if *v {
+				sb.WriteString("b1;")
+			} else {
+				sb.WriteString("b0;")
+			}
+		}
+	}
+	return sb.String(), hasNull
+}
+
+// 一行中所有字段的 key, 用于去重和集合运算
+func rowKey(row rows.Row, width int) string {
+	var values []interface{}
+	for i := 0; i < width; i++ {
+		values = append(values, row.IndexOf(i))
+	}
+	key, _ := hashKey(values)
+	return key
+}
@@ -0,0 +1,59 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"sql-engine/rows"
+	"sql-engine/sqlerr"
+	"sync/atomic"
+)
+
+type memoryTrackerKey struct{}
+
+// 统计一次查询中 sort、join、aggregate 等节点缓存的数据量, 超过上限时查询失败.
+// 只是粗略估计, 用于避免一个查询占满内存
+type memoryTracker struct {
+	limit int64
+	used  int64
+}
+
+// 为查询设置内存上限, limit <= 0 时不限制
+func WithMemoryLimit(ctx context.Context, limit int64) context.Context {
+	if limit <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, memoryTrackerKey{}, &memoryTracker{limit: limit})
+}
+
+// 记录缓存了一行数据
+func reserveRow(ctx context.Context, row rows.Row, width int) error {
+	tracker, ok := ctx.Value(memoryTrackerKey{}).(*memoryTracker)
+	if !ok {
+		return nil
+	}
+	size := int64(24 + 16*width)
+	for i := 0; i < width; i++ {
+		size += valueSize(row.IndexOf(i))
+	}
+	// union 的子查询并发执行, 需要原子操作
+	if used := atomic.AddInt64(&tracker.used, size); used > tracker.limit {
+		return &sqlerr.ExecutionError{
+			Msg: fmt.Sprintf("memory limit exceeded: %d bytes used, limit %d", used, tracker.limit),
+		}
+	}
+	return nil
+}
+
+func valueSize(v interface{}) int64 {
+	switch t := v.(type) {
+	case *string:
+		if t != nil {
+			return int64(16 + len(*t))
+		}
+	case *int64, *float64:
+		return 8
+	case *bool:
+		return 1
+	}
+	return 0
+}
@@ -2,6 +2,7 @@ package plan
 
 import (
 	"sql-engine/expression"
+	"sql-engine/rows"
 )
 
 // 将过滤条件下推至 relation 中
@@ -11,7 +12,7 @@ func (opt PushDownPredicateIntoSource) Apply(plan Plan) Plan {
 	return Transform(plan, func(p Plan) Plan {
 		if filter, ok := p.(*Filter); ok {
 			if relation, ok := filter.Child.(*Relation); ok {
-				conditions := opt.splitConjunctivePredicates(filter.Condition)
+				conditions := splitConjunctivePredicates(filter.Condition)
 				relation.PushDownPredicate = conditions
 			}
 		}
@@ -19,11 +20,119 @@ func (opt PushDownPredicateIntoSource) Apply(plan Plan) Plan {
 	})
 }
 
+// 从 join 条件中提取等值条件作为 hash join 的 key
+type ExtractEquiJoinKeys struct{}
+
+func (opt ExtractEquiJoinKeys) Apply(plan Plan) Plan {
+	return Transform(plan, func(p Plan) Plan {
+		join, ok := p.(*Join)
+		if !ok || join.Condition == nil {
+			return p
+		}
+		schema := join.joinSchema()
+		leftWidth := len(join.Left.GetSchema())
+		join.LeftKeys, join.RightKeys, join.Residual = nil, nil, nil
+		var residual []expression.Expression
+		for _, condition := range splitConjunctivePredicates(join.Condition) {
+			eq, ok := condition.(*expression.EqualTo)
+			if !ok {
+				residual = append(residual, condition)
+				continue
+			}
+			leftSide := opt.side(eq.Left, leftWidth)
+			rightSide := opt.side(eq.Right, leftWidth)
+			var leftKey, rightKey expression.Expression
+			if leftSide == 1 && rightSide == 2 {
+				leftKey, rightKey = eq.Left, eq.Right
+			} else if leftSide == 2 && rightSide == 1 {
+				leftKey, rightKey = eq.Right, eq.Left
+			}
+			// 字符串与数值比较时会做类型转换，无法直接 hash，保留为普通条件
+			if leftKey == nil || !opt.hashable(leftKey.GetSchema(schema), rightKey.GetSchema(schema)) {
+				residual = append(residual, condition)
+				continue
+			}
+			join.LeftKeys = append(join.LeftKeys, leftKey)
+			join.RightKeys = append(join.RightKeys, rightKey)
+		}
+		if len(join.LeftKeys) != 0 {
+			join.Residual = combineConjunctivePredicates(residual)
+		}
+		return p
+	})
+}
+
+// 返回表达式引用的字段来自 join 的哪一侧, 1 为左侧, 2 为右侧, 0 为没有引用字段或两侧都有引用
+func (opt ExtractEquiJoinKeys) side(expr expression.Expression, leftWidth int) int {
+	side := 0
+	mixed := false
+	expression.Transform(expr, func(e expression.Expression) expression.Expression {
+		if attr, ok := e.(*expression.Attribute); ok {
+			s := 2
+			if attr.Index() < leftWidth {
+				s = 1
+			}
+			if side != 0 && side != s {
+				mixed = true
+			}
+			side = s
+		}
+		return e
+	})
+	if mixed {
+		return 0
+	}
+	return side
+}
+
+func (opt ExtractEquiJoinKeys) hashable(left, right rows.StructField) bool {
+	if left.DataType == right.DataType {
+		return true
+	}
+	numeric := func(t rows.DataType) bool {
+		return t == rows.Int || t == rows.Float
+	}
+	return numeric(left.DataType) && numeric(right.DataType)
+}
+
+// 聚合 union all 的结果时, 以每个子查询作为一个分区分别聚合再合并, 不需要经过 union 汇总数据
+type PartitionAggregateByUnion struct{}
+
+func (PartitionAggregateByUnion) Apply(plan Plan) Plan {
+	return Transform(plan, func(p Plan) Plan {
+		agg, ok := p.(*Aggregate)
+		if !ok || len(agg.Partitions) != 0 {
+			return p
+		}
+		// 子查询只改变字段名, 字段的位置不变
+		child := agg.Child
+		if subquery, ok := child.(*Subquery); ok {
+			child = subquery.Child
+		}
+		if union, ok := child.(*Union); ok && len(union.Children) > 1 {
+			agg.Partitions = append([]Plan{}, union.Children...)
+		}
+		return p
+	})
+}
+
 // 使用 and 递归拆分表达式
-func (opt PushDownPredicateIntoSource) splitConjunctivePredicates(condition expression.Expression) []expression.Expression {
+func splitConjunctivePredicates(condition expression.Expression) []expression.Expression {
 	if and, ok := condition.(*expression.And); ok {
-		return append(opt.splitConjunctivePredicates(and.Left), opt.splitConjunctivePredicates(and.Right)...)
+		return append(splitConjunctivePredicates(and.Left), splitConjunctivePredicates(and.Right)...)
 	} else {
 		return []expression.Expression{condition}
 	}
+}
+
+// 使用 and 将表达式合并，为空时返回 nil
+func combineConjunctivePredicates(conditions []expression.Expression) expression.Expression {
+	if len(conditions) == 0 {
+		return nil
+	}
+	result := conditions[0]
+	for _, condition := range conditions[1:] {
+		result = &expression.And{BinaryExpr: expression.BinaryExpr{Left: result, Right: condition}}
+	}
+	return result
 }
\ No newline at end of file
@@ -1,14 +1,19 @@
 package plan
 
 import (
+	"context"
 	"sql-engine/expression"
 	"sql-engine/rows"
 	"sql-engine/source"
 )
 
+// 基于拉取的迭代器执行, 使用前需要 Open, 使用后需要 Close, Open 返回错误时也需要 Close.
+// ctx 取消后 Next 返回错误
 type Plan interface {
-	Execute() rows.Dataset
-	Print(level int)
+	Open(ctx context.Context) error
+	Next() (rows.Row, error) // 数据读完时返回 nil
+	Close()
+	Describe() string // 节点自身的描述, 不包含子节点, 完整输出见 printer.go
 	GetSchema() []rows.StructField
 	GetChildren() []*Plan
 }
@@ -29,17 +34,49 @@ type Relation struct {
 	Alias             string
 	DataSource        source.Source
 	PushDownPredicate []expression.Expression
+	ctx               context.Context
 }
 
 type Union struct {
-	Children []Plan
+	Children    []Plan
+	Parallelism int // 同时执行的子查询数量
+	ctx         context.Context
+	rowCh       chan unionOption
+	done        chan struct{}
+}
+
+// intersect: 左右两侧都存在的行, All 为 false 时结果去重
+type Intersect struct {
+	Left  Plan
+	Right Plan
+	All   bool
+	setOperation
+}
+
+// except: 左侧存在而右侧不存在的行, All 为 false 时结果去重
+type Except struct {
+	Left  Plan
+	Right Plan
+	All   bool
+	setOperation
+}
+
+// 集合运算的执行状态, 右侧数据读入内存, 左侧逐行读取
+type setOperation struct {
+	ctx    context.Context
+	width  int
+	counts map[string]int  // 右侧每一行出现的次数
+	seen   map[string]bool // 已经输出的行, 去重时使用
 }
 
 type Aggregate struct {
 	Child          Plan
 	GroupExprs     []expression.Expression // group by 后的表达式
 	AggregateExprs []expression.Expression // select 中的[聚合]表达式
+	Partitions     []Plan                  // 不为空时代替 Child 执行, 分别聚合后合并, 由优化器根据 union all 设置
 	schemaCache    []rows.StructField
+	aggFunctions   []expression.AggFunction
+	groups         []*group // 待输出的分组
 }
 
 type Subquery struct {
@@ -49,13 +86,109 @@ type Subquery struct {
 }
 
 type Sort struct {
-	Child Plan
-	Order []SortOrder
+	Child  Plan
+	Order  []SortOrder
+	result []rows.Row
 }
 
 type Limit struct {
+	Child   Plan
+	Count   int
+	Offset  int // 跳过的行数
+	emitted int
+}
+
+type Distinct struct {
 	Child Plan
-	Count int
+	ctx   context.Context
+	seen  map[string]bool
+}
+
+// with recursive 定义的查询. 先执行 Anchor, 之后以上一轮新产生的数据作为 WorkTable 重复执行 Recursive,
+// 直到没有新数据. 已经输出过的行不再参与迭代, 防止数据成环时无限循环
+type RecursiveCte struct {
+	Name      string
+	Anchor    Plan
+	Recursive Plan // 其中通过 WorkTable 引用上一轮的结果
+	Distinct  bool // union 时去除所有轮次中的重复数据, union all 时保留
+	MaxDepth  int  // 最大迭代次数, 超过时报错
+
+	ctx     context.Context
+	work    []rows.Row // 上一轮新产生的数据, 供 WorkTable 读取
+	pending []rows.Row
+	seen    map[string]bool
+	depth   int
+}
+
+// 递归查询中对自身的引用
+type WorkTable struct {
+	Cte  *RecursiveCte
+	rows []rows.Row
+}
+
+// 计算窗口函数, 输出子节点的字段之后加上每个窗口函数的结果. 同一节点中的窗口函数分区和排序相同
+type Window struct {
+	Child       Plan
+	WindowExprs []expression.Expression // 带别名的 WindowExpr
+	PartitionBy []expression.Expression
+	Order       []SortOrder
+	schemaCache []rows.StructField
+
+	result []rows.Row
+}
+
+// 执行子节点前先执行表达式中引用的标量子查询
+type ScalarSubqueries struct {
+	Child      Plan
+	Subqueries []Plan
+	exprs      []*expression.ScalarSubquery
+}
+
+type JoinType int
+
+const (
+	InnerJoin JoinType = iota
+	LeftOuterJoin
+	RightOuterJoin
+	FullOuterJoin
+	CrossJoin
+	LeftSemiJoin // 只输出左侧存在匹配的行, 用于 in (subquery) 和 exists
+	LeftAntiJoin // 只输出左侧不存在匹配的行, 用于 not in (subquery) 和 not exists
+)
+
+var JoinTypeName = map[JoinType]string{
+	InnerJoin:      "inner",
+	LeftOuterJoin:  "left outer",
+	RightOuterJoin: "right outer",
+	FullOuterJoin:  "full outer",
+	CrossJoin:      "cross",
+	LeftSemiJoin:   "left semi",
+	LeftAntiJoin:   "left anti",
+}
+
+type Join struct {
+	Left      Plan
+	Right     Plan
+	JoinType  JoinType
+	Condition expression.Expression // cross join 时为空
+	// 由优化器从 Condition 中提取的等值条件, 不为空时使用 hash join
+	LeftKeys    []expression.Expression
+	RightKeys   []expression.Expression
+	Residual    expression.Expression // 除等值条件外剩余的条件，可为空
+	NullAware   bool                  // not in 的 anti join, 条件结果为 null 时也视为匹配
+	schemaCache []rows.StructField
+
+	// 执行时状态
+	right        []rows.Row
+	buildTable   map[string][]int // hash key -> right 中的下标
+	rightMatched []bool
+	pending      []rows.Row // 当前左侧行已匹配但未返回的数据
+	leftDone     bool
+	leftNull     rows.Row
+	rightNull    rows.Row
+	leftWidth    int
+	rightWidth   int
+	rightHasNull bool // 右侧存在 key 为 null 的行
 }
 
 func (p *Project) GetChildren() []*Plan {
@@ -70,6 +203,14 @@ func (r *Relation) GetChildren() []*Plan {
 	return []*Plan{}
 }
 
+func (i *Intersect) GetChildren() []*Plan {
+	return []*Plan{&i.Left, &i.Right}
+}
+
+func (e *Except) GetChildren() []*Plan {
+	return []*Plan{&e.Left, &e.Right}
+}
+
 func (u *Union) GetChildren() []*Plan {
 	var result []*Plan
 	for i := range u.Children {
@@ -78,8 +219,16 @@ func (u *Union) GetChildren() []*Plan {
 	return result
 }
 
+// 有 Partitions 时 Child 只用于确定 schema, 不会执行
 func (a *Aggregate) GetChildren() []*Plan {
-	return []*Plan{&a.Child}
+	if len(a.Partitions) == 0 {
+		return []*Plan{&a.Child}
+	}
+	var result []*Plan
+	for i := range a.Partitions {
+		result = append(result, &a.Partitions[i])
+	}
+	return result
 }
 
 func (s *Subquery) GetChildren() []*Plan {
@@ -94,6 +243,35 @@ func (l *Limit) GetChildren() []*Plan {
 	return []*Plan{&l.Child}
 }
 
+func (d *Distinct) GetChildren() []*Plan {
+	return []*Plan{&d.Child}
+}
+
+func (j *Join) GetChildren() []*Plan {
+	return []*Plan{&j.Left, &j.Right}
+}
+
+func (r *RecursiveCte) GetChildren() []*Plan {
+	return []*Plan{&r.Anchor, &r.Recursive}
+}
+
+// 不返回所引用的 RecursiveCte, 避免遍历时成环
+func (w *WorkTable) GetChildren() []*Plan {
+	return []*Plan{}
+}
+
+func (w *Window) GetChildren() []*Plan {
+	return []*Plan{&w.Child}
+}
+
+func (s *ScalarSubqueries) GetChildren() []*Plan {
+	result := []*Plan{&s.Child}
+	for i := range s.Subqueries {
+		result = append(result, &s.Subqueries[i])
+	}
+	return result
+}
+
 func Transform(plan Plan, fn func(p Plan) Plan) Plan {
 	children := plan.GetChildren()
 	for _, child := range children {
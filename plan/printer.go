@@ -1,92 +1,275 @@
 package plan
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"reflect"
+	"sql-engine/expression"
 	"strings"
 )
 
-func (p *Project) Print(level int) {
-	PrintBlank(level)
-	fmt.Print("Project[")
-	for i, e := range p.ProjectList {
-		fmt.Printf(e.Print())
-		if i != len(p.ProjectList)-1 {
-			fmt.Printf(", ")
-		}
+func (p *Project) Describe() string {
+	return fmt.Sprintf("Project[%s]", printExprs(p.ProjectList))
+}
+
+func (f *Filter) Describe() string {
+	return fmt.Sprintf("Filter[%s]", f.Condition.Print())
+}
+
+func (r *Relation) Describe() string {
+	s := fmt.Sprintf("Relation(input = '%s', alias = '%s'", r.Input, r.Alias)
+	if len(r.PushDownPredicate) != 0 {
+		s += fmt.Sprintf(", pushDown = [%s]", printExprs(r.PushDownPredicate))
 	}
-	fmt.Println("]")
-	p.Child.Print(level + 1)
+	return s + ")"
+}
+
+func (u *Union) Describe() string {
+	return "Union"
 }
 
-func (f *Filter) Print(level int) {
-	PrintBlank(level)
-	fmt.Printf("Filter[%s]\n", f.Condition.Print())
-	f.Child.Print(level + 1)
+func (i *Intersect) Describe() string {
+	if i.All {
+		return "Intersect(all)"
+	}
+	return "Intersect"
 }
 
-func (r *Relation) Print(level int) {
-	PrintBlank(level)
-	fmt.Printf("Relation(input = '%s', alias = '%s')\n", r.Input, r.Alias)
+func (e *Except) Describe() string {
+	if e.All {
+		return "Except(all)"
+	}
+	return "Except"
 }
 
-func (u *Union) Print(level int) {
-	PrintBlank(level)
-	fmt.Println("Union:")
-	for _, child := range u.Children {
-		child.Print(level + 1)
+func (a *Aggregate) Describe() string {
+	if len(a.Partitions) != 0 {
+		return fmt.Sprintf("Aggregate([%s], [%s], partitions = %d)", printExprs(a.GroupExprs), printExprs(a.AggregateExprs), len(a.Partitions))
 	}
+	return fmt.Sprintf("Aggregate([%s], [%s])", printExprs(a.GroupExprs), printExprs(a.AggregateExprs))
+}
+
+func (s *Subquery) Describe() string {
+	return "SubqueryAlias " + s.Alias
 }
 
-func (a *Aggregate) Print(level int) {
-	PrintBlank(level)
-	fmt.Print("Aggregate([")
-	for i, expr := range a.GroupExprs {
-		fmt.Print(expr.Print())
-		if i != len(a.GroupExprs)-1 {
-			fmt.Print(", ")
+func (s *Sort) Describe() string {
+	var orders []string
+	for _, order := range s.Order {
+		if order.Reverse {
+			orders = append(orders, order.Expr.Print()+" desc")
+		} else {
+			orders = append(orders, order.Expr.Print())
 		}
 	}
-	fmt.Print("], [")
-	for i, expr := range a.AggregateExprs {
-		fmt.Print(expr.Print())
-		if i != len(a.AggregateExprs)-1 {
-			fmt.Print(", ")
+	return fmt.Sprintf("Sort(%s)", strings.Join(orders, ", "))
+}
+
+func (l *Limit) Describe() string {
+	if l.Offset != 0 {
+		return fmt.Sprintf("Limit(%d, offset = %d)", l.Count, l.Offset)
+	}
+	return fmt.Sprintf("Limit(%d)", l.Count)
+}
+
+func (d *Distinct) Describe() string {
+	return "Distinct"
+}
+
+func (j *Join) Describe() string {
+	if len(j.LeftKeys) != 0 {
+		var keys []string
+		for i := range j.LeftKeys {
+			keys = append(keys, fmt.Sprintf("%s = %s", j.LeftKeys[i].Print(), j.RightKeys[i].Print()))
+		}
+		if j.Residual != nil {
+			return fmt.Sprintf("HashJoin(%s, [%s], %s)", JoinTypeName[j.JoinType], strings.Join(keys, ", "), j.Residual.Print())
 		}
+		return fmt.Sprintf("HashJoin(%s, [%s])", JoinTypeName[j.JoinType], strings.Join(keys, ", "))
+	} else if j.Condition != nil {
+		return fmt.Sprintf("Join(%s, %s)", JoinTypeName[j.JoinType], j.Condition.Print())
+	}
+	return fmt.Sprintf("Join(%s)", JoinTypeName[j.JoinType])
+}
+
+func (r *RecursiveCte) Describe() string {
+	if r.Distinct {
+		return fmt.Sprintf("RecursiveCte(%s, union)", r.Name)
 	}
-	fmt.Println("])")
-	a.Child.Print(level + 1)
+	return fmt.Sprintf("RecursiveCte(%s, union all)", r.Name)
 }
 
-func (s *Subquery) Print(level int) {
-	PrintBlank(level)
-	fmt.Printf("SubqueryAlias %s\n", s.Alias)
-	s.Child.Print(level + 1)
+func (w *WorkTable) Describe() string {
+	return fmt.Sprintf("WorkTable(%s)", w.Cte.Name)
 }
 
-func (s *Sort) Print(level int) {
-	PrintBlank(level)
-	fmt.Print("Sort(")
-	for i, order := range s.Order {
-		fmt.Print(order.Expr.Print())
+func (w *Window) Describe() string {
+	var orders []string
+	for _, order := range w.Order {
 		if order.Reverse {
-			fmt.Print(" desc")
+			orders = append(orders, order.Expr.Print()+" desc")
+		} else {
+			orders = append(orders, order.Expr.Print())
 		}
-		if i != len(s.Order)-1 {
-			fmt.Print(", ")
+	}
+	return fmt.Sprintf("Window([%s], [%s], [%s])", printExprs(w.WindowExprs), printExprs(w.PartitionBy), strings.Join(orders, ", "))
+}
+
+func (s *ScalarSubqueries) Describe() string {
+	return fmt.Sprintf("ScalarSubqueries(%d)", len(s.Subqueries))
+}
+
+// 以缩进的树按行输出 plan, 每层缩进 4 个空格
+func TreeLines(p Plan) []string {
+	var lines []string
+	var walk func(p Plan, level int)
+	walk = func(p Plan, level int) {
+		lines = append(lines, strings.Repeat("    ", level)+"- "+p.Describe())
+		for _, child := range p.GetChildren() {
+			walk(*child, level+1)
 		}
 	}
-	fmt.Println(")")
-	s.Child.Print(level + 1)
+	walk(p, 0)
+	return lines
 }
 
-func (l *Limit) Print(level int) {
-	PrintBlank(level)
-	fmt.Printf("Limit(%d)\n", l.Count)
-	l.Child.Print(level + 1)
+func WriteTree(w io.Writer, p Plan) error {
+	for _, line := range TreeLines(p) {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TreeString(p Plan) string {
+	var sb strings.Builder
+	_ = WriteTree(&sb, p)
+	return sb.String()
+}
+
+// 单行输出 plan, 子节点写在父节点之后的括号中, 如 Project[#name] <- (Relation(...))
+func CompactString(p Plan) string {
+	s := p.Describe()
+	var children []string
+	for _, child := range p.GetChildren() {
+		children = append(children, CompactString(*child))
+	}
+	if len(children) != 0 {
+		s += " <- (" + strings.Join(children, ", ") + ")"
+	}
+	return s
+}
+
+func WriteCompact(w io.Writer, p Plan) error {
+	_, err := fmt.Fprintln(w, CompactString(p))
+	return err
 }
 
-func PrintBlank(level int) {
-	blank := strings.Repeat("    ", level)
-	fmt.Print(blank)
-	fmt.Print("- ")
+// plan 的 json 结构
+type Node struct {
+	Type        string                        `json:"type"`
+	Description string                        `json:"description"`
+	Properties  map[string]interface{}        `json:"properties,omitempty"`
+	Expressions map[string][]*expression.Node `json:"expressions,omitempty"`
+	Children    []*Node                       `json:"children,omitempty"`
+}
+
+func ToNode(p Plan) *Node {
+	// explain analyze 的统计作为被包装节点的属性
+	if a, ok := p.(*analyzed); ok {
+		node := ToNode(a.Child)
+		node.Properties["rows"] = a.rows
+		node.Properties["time"] = a.elapsed.String()
+		return node
+	}
+	node := &Node{
+		Type:        reflect.TypeOf(p).Elem().Name(),
+		Description: p.Describe(),
+		Properties:  make(map[string]interface{}),
+		Expressions: make(map[string][]*expression.Node),
+	}
+	addExprs := func(name string, exprs ...expression.Expression) {
+		for _, e := range exprs {
+			if e != nil {
+				node.Expressions[name] = append(node.Expressions[name], expression.ToNode(e))
+			}
+		}
+	}
+	switch t := p.(type) {
+	case *Project:
+		addExprs("projectList", t.ProjectList...)
+	case *Filter:
+		addExprs("condition", t.Condition)
+	case *Relation:
+		node.Properties["input"] = t.Input
+		node.Properties["alias"] = t.Alias
+		addExprs("pushDownPredicate", t.PushDownPredicate...)
+	case *Aggregate:
+		addExprs("groupExprs", t.GroupExprs...)
+		addExprs("aggregateExprs", t.AggregateExprs...)
+		if len(t.Partitions) != 0 {
+			node.Properties["partitions"] = len(t.Partitions)
+		}
+	case *Subquery:
+		node.Properties["alias"] = t.Alias
+	case *Sort:
+		var reverse []bool
+		for _, order := range t.Order {
+			addExprs("order", order.Expr)
+			reverse = append(reverse, order.Reverse)
+		}
+		node.Properties["reverse"] = reverse
+	case *Window:
+		addExprs("windowExprs", t.WindowExprs...)
+		addExprs("partitionBy", t.PartitionBy...)
+		var reverse []bool
+		for _, order := range t.Order {
+			addExprs("order", order.Expr)
+			reverse = append(reverse, order.Reverse)
+		}
+		node.Properties["reverse"] = reverse
+	case *Limit:
+		node.Properties["count"] = t.Count
+		node.Properties["offset"] = t.Offset
+	case *Intersect:
+		node.Properties["all"] = t.All
+	case *Except:
+		node.Properties["all"] = t.All
+	case *RecursiveCte:
+		node.Properties["name"] = t.Name
+		node.Properties["distinct"] = t.Distinct
+		node.Properties["maxDepth"] = t.MaxDepth
+	case *WorkTable:
+		node.Properties["name"] = t.Cte.Name
+	case *Join:
+		node.Properties["joinType"] = JoinTypeName[t.JoinType]
+		if t.NullAware {
+			node.Properties["nullAware"] = true
+		}
+		addExprs("condition", t.Condition)
+		addExprs("leftKeys", t.LeftKeys...)
+		addExprs("rightKeys", t.RightKeys...)
+		addExprs("residual", t.Residual)
+	}
+	for _, child := range p.GetChildren() {
+		node.Children = append(node.Children, ToNode(*child))
+	}
+	return node
+}
+
+func WriteJSON(w io.Writer, p Plan) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(ToNode(p))
+}
+
+func printExprs(exprs []expression.Expression) string {
+	var result []string
+	for _, e := range exprs {
+		result = append(result, e.Print())
+	}
+	return strings.Join(result, ", ")
 }
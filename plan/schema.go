@@ -40,31 +40,60 @@ func (f *Filter) GetSchema() []rows.StructField {
 	return schema
 }
 
+// 有别名时字段名加上 'alias.' 前缀
 func (r *Relation) GetSchema() []rows.StructField {
-	return r.DataSource.GetSchema()
+	schema := r.DataSource.GetSchema()
+	if r.Alias == "" {
+		return schema
+	}
+	var result []rows.StructField
+	for _, field := range schema {
+		result = append(result, rows.StructField{
+			Name:     r.Alias + "." + field.Name,
+			DataType: field.DataType,
+		})
+	}
+	return result
 }
 
 func (u *Union) GetSchema() []rows.StructField {
 	first := u.Children[0].GetSchema()
 	for i := 1; i < len(u.Children); i++ {
-		other := u.Children[i].GetSchema()
-		if len(first) != len(other) {
-			panic("union all length is not match")
-		}
-		// 比较每一个字段类型
-		for i, field := range first {
-			otherField := other[i]
-			if field.DataType != otherField.DataType {
-				panic(fmt.Sprintf(
-					"union all field data type is not match, %s and %s",
-					rows.DataTypeName[field.DataType],
-					rows.DataTypeName[otherField.DataType]))
-			}
-		}
+		checkSetSchema("union", first, u.Children[i].GetSchema())
 	}
 	return first
 }
 
+func (i *Intersect) GetSchema() []rows.StructField {
+	left := i.Left.GetSchema()
+	checkSetSchema("intersect", left, i.Right.GetSchema())
+	return left
+}
+
+func (e *Except) GetSchema() []rows.StructField {
+	left := e.Left.GetSchema()
+	checkSetSchema("except", left, e.Right.GetSchema())
+	return left
+}
+
+// 集合运算两侧的字段数量和类型需要一致
+func checkSetSchema(name string, first, other []rows.StructField) {
+	if len(first) != len(other) {
+		panic(name + " length is not match")
+	}
+	// 比较每一个字段类型
+	for i, field := range first {
+		otherField := other[i]
+		if field.DataType != otherField.DataType {
+			panic(fmt.Sprintf(
+				"%s field data type is not match, %s and %s",
+				name,
+				rows.DataTypeName[field.DataType],
+				rows.DataTypeName[otherField.DataType]))
+		}
+	}
+}
+
 func (a *Aggregate) GetSchema() []rows.StructField {
 	if a.schemaCache != nil {
 		return a.schemaCache
@@ -88,6 +117,9 @@ func (a *Aggregate) GetSchema() []rows.StructField {
 		if names[expr.Print()] {
 			return
 		}
+		if proxy, ok := expr.(*expression.ExprProxy); ok {
+			expr = proxy.Expr
+		}
 		// 聚合函数不需要检查, attribute 不应该存在
 		switch t := expr.(type) {
 		case expression.AggFunction:
@@ -138,6 +170,88 @@ func (s *Subquery) GetSchema() []rows.StructField {
 	return result
 }
 
+func (d *Distinct) GetSchema() []rows.StructField {
+	return d.Child.GetSchema()
+}
+
+// 子节点字段之后加上窗口函数的结果
+func (w *Window) GetSchema() []rows.StructField {
+	if w.schemaCache != nil {
+		return w.schemaCache
+	}
+	option := w.Child.GetSchema()
+	result := append([]rows.StructField{}, option...)
+	for _, e := range w.WindowExprs {
+		alias := e.(*expression.Alias)
+		windowExpr := alias.Child.(*expression.WindowExpr)
+		switch windowExpr.Function.(type) {
+		case expression.WindowFunction, expression.AggFunction:
+		default:
+			panic(fmt.Sprintf("%s can not be used as window function", windowExpr.Function.Print()))
+		}
+		if frame := windowExpr.Frame; frame != nil {
+			if frame.Start.Type == expression.UnboundedFollowing || frame.End.Type == expression.UnboundedPreceding {
+				panic("frame can not start from unbounded following or end at unbounded preceding")
+			}
+			hasOffset := func(b expression.FrameBound) bool {
+				return b.Type == expression.Preceding || b.Type == expression.Following
+			}
+			// 按排序值确定边界时, 只能有一个数值类型的排序字段
+			if frame.Range && (hasOffset(frame.Start) || hasOffset(frame.End)) {
+				if len(w.Order) != 1 {
+					panic("range frame with offset requires exactly one order by expression")
+				}
+				if t := w.Order[0].Expr.GetSchema(option).DataType; t != rows.Int && t != rows.Float {
+					panic("range frame with offset requires numeric order by expression")
+				}
+			}
+		}
+		result = append(result, rows.StructField{
+			Name:     alias.Name,
+			DataType: windowExpr.Function.GetSchema(option).DataType,
+		})
+	}
+	w.schemaCache = result
+	return result
+}
+
+func (s *ScalarSubqueries) GetSchema() []rows.StructField {
+	return s.Child.GetSchema()
+}
+
+// semi join 和 anti join 只输出左侧字段
+func (j *Join) GetSchema() []rows.StructField {
+	schema := j.joinSchema()
+	if j.JoinType == LeftSemiJoin || j.JoinType == LeftAntiJoin {
+		leftWidth := len(j.Left.GetSchema())
+		return schema[:leftWidth:leftWidth]
+	}
+	return schema
+}
+
+// 左右两侧字段直接拼接，字段名不允许重复, join 条件基于此求值
+func (j *Join) joinSchema() []rows.StructField {
+	if j.schemaCache != nil {
+		return j.schemaCache
+	}
+	var result []rows.StructField
+	names := make(map[string]bool)
+	for _, field := range append(j.Left.GetSchema(), j.Right.GetSchema()...) {
+		if names[field.Name] {
+			panic(fmt.Sprintf("field '%s' is duplicate in join, please use alias", field.Name))
+		}
+		names[field.Name] = true
+		result = append(result, field)
+	}
+	if j.Condition != nil {
+		if j.Condition.GetSchema(result).DataType != rows.Boolean {
+			panic("join condition must be boolean")
+		}
+	}
+	j.schemaCache = result
+	return result
+}
+
 func (s *Sort) GetSchema() []rows.StructField {
 	return s.Child.GetSchema()
 }
@@ -167,3 +281,26 @@ func genFields() func(field rows.StructField, bakName string) rows.StructField {
 		return rows.StructField{Name: name, DataType: field.DataType}
 	}
 }
+
+// 与 union 相同, 递归部分的字段数量和类型需要与 anchor 一致
+func (r *RecursiveCte) GetSchema() []rows.StructField {
+	anchor := r.Anchor.GetSchema()
+	recursive := r.Recursive.GetSchema()
+	if len(anchor) != len(recursive) {
+		panic(fmt.Sprintf("recursive query '%s' field length is not match", r.Name))
+	}
+	for i, field := range anchor {
+		if field.DataType != recursive[i].DataType {
+			panic(fmt.Sprintf(
+				"recursive query '%s' field data type is not match, %s and %s",
+				r.Name,
+				rows.DataTypeName[field.DataType],
+				rows.DataTypeName[recursive[i].DataType]))
+		}
+	}
+	return anchor
+}
+
+func (w *WorkTable) GetSchema() []rows.StructField {
+	return w.Cte.Anchor.GetSchema()
+}
@@ -0,0 +1,191 @@
+package plan
+
+import (
+	"sort"
+	"sql-engine/expression"
+	"sql-engine/rows"
+	"sql-engine/util/pointer"
+)
+
+func (w *Window) partitionKey(row rows.Row) string {
+	var values []interface{}
+	for _, e := range w.PartitionBy {
+		values = append(values, e.Eval(row))
+	}
+	key, _ := hashKey(values)
+	return key
+}
+
+// 计算一个分区中每个窗口函数的结果
+func (w *Window) evalPartition(partition []rows.Row) [][]interface{} {
+	// 排序值相同的行属于同一组
+	peers := make([]int, len(partition))
+	var lastKey string
+	for i, row := range partition {
+		var values []interface{}
+		for _, order := range w.Order {
+			values = append(values, order.Expr.Eval(row))
+		}
+		key, _ := hashKey(values)
+		if i != 0 {
+			peers[i] = peers[i-1]
+			if key != lastKey {
+				peers[i] += 1
+			}
+		}
+		lastKey = key
+	}
+	var result [][]interface{}
+	for _, e := range w.WindowExprs {
+		windowExpr := e.(*expression.Alias).Child.(*expression.WindowExpr)
+		p := &expression.WindowPartition{
+			Rows:   partition,
+			Peers:  peers,
+			Frames: w.frames(w.frame(windowExpr), partition, peers),
+		}
+		switch f := windowExpr.Function.(type) {
+		case expression.WindowFunction:
+			result = append(result, f.EvalWindow(p))
+		case expression.AggFunction:
+			result = append(result, aggregateFrames(f, p))
+		}
+	}
+	return result
+}
+
+// 没有指定时, 有排序则从分区开始到当前行及排序值相同的行, 否则为整个分区
+func (w *Window) frame(e *expression.WindowExpr) expression.WindowFrame {
+	if e.Frame != nil {
+		return *e.Frame
+	}
+	if len(w.Order) == 0 {
+		return expression.WindowFrame{
+			Start: expression.FrameBound{Type: expression.UnboundedPreceding},
+			End:   expression.FrameBound{Type: expression.UnboundedFollowing},
+		}
+	}
+	return expression.WindowFrame{
+		Range: true,
+		Start: expression.FrameBound{Type: expression.UnboundedPreceding},
+		End:   expression.FrameBound{Type: expression.CurrentRow},
+	}
+}
+
+// 计算每一行的窗口帧 [start, end)
+func (w *Window) frames(frame expression.WindowFrame, partition []rows.Row, peers []int) [][2]int {
+	n := len(partition)
+	// 每组排序值相同的行的范围
+	groupStart := make([]int, peers[n-1]+1)
+	groupEnd := make([]int, peers[n-1]+1)
+	for i := n - 1; i >= 0; i-- {
+		groupStart[peers[i]] = i
+	}
+	for i := 0; i < n; i++ {
+		groupEnd[peers[i]] = i + 1
+	}
+	// range 使用偏移量时的排序值, 倒序时取反使其递增, null 排在非 null 之前或之后
+	var keys []*float64
+	lo, hi := 0, n
+	if frame.Range && len(w.Order) == 1 {
+		keys = make([]*float64, n)
+		for i, row := range partition {
+			value := w.Order[0].Expr.Eval(row)
+			if v, ok := value.(*int64); ok && v != nil {
+				keys[i] = pointer.Float64(float64(*v))
+			} else if v, ok := value.(*float64); ok && v != nil {
+				keys[i] = pointer.Float64(*v)
+			}
+			if keys[i] != nil && w.Order[0].Reverse {
+				*keys[i] = -*keys[i]
+			}
+		}
+		for lo < n && keys[lo] == nil {
+			lo += 1
+		}
+		for hi > lo && keys[hi-1] == nil {
+			hi -= 1
+		}
+	}
+	bound := func(i int, b expression.FrameBound, isStart bool) int {
+		switch b.Type {
+		case expression.UnboundedPreceding:
+			return 0
+		case expression.UnboundedFollowing:
+			return n
+		}
+		if !frame.Range {
+			offset := 0
+			if b.Type == expression.Preceding {
+				offset = -int(b.Offset)
+			} else if b.Type == expression.Following {
+				offset = int(b.Offset)
+			}
+			if isStart {
+				return i + offset
+			}
+			return i + offset + 1
+		}
+		if b.Type == expression.CurrentRow || keys[i] == nil {
+			if isStart {
+				return groupStart[peers[i]]
+			}
+			return groupEnd[peers[i]]
+		}
+		target := *keys[i] - float64(b.Offset)
+		if b.Type == expression.Following {
+			target = *keys[i] + float64(b.Offset)
+		}
+		if isStart {
+			return lo + sort.Search(hi-lo, func(k int) bool { return *keys[lo+k] >= target })
+		}
+		return lo + sort.Search(hi-lo, func(k int) bool { return *keys[lo+k] > target })
+	}
+	clamp := func(i int) int {
+		if i < 0 {
+			return 0
+		} else if i > n {
+			return n
+		}
+		return i
+	}
+	result := make([][2]int, n)
+	for i := range partition {
+		start, end := clamp(bound(i, frame.Start, true)), clamp(bound(i, frame.End, false))
+		if end < start {
+			end = start
+		}
+		result[i] = [2]int{start, end}
+	}
+	return result
+}
+
+// 聚合函数在每一行的窗口帧上计算. 帧的起点不变且终点递增时逐行累加, 否则每一行重新计算
+func aggregateFrames(agg expression.AggFunction, p *expression.WindowPartition) []interface{} {
+	result := make([]interface{}, len(p.Rows))
+	incremental := true
+	for i, frame := range p.Frames {
+		if frame[0] != p.Frames[0][0] || (i != 0 && frame[1] < p.Frames[i-1][1]) {
+			incremental = false
+			break
+		}
+	}
+	if incremental {
+		acc := agg.NewAccumulator()
+		next := p.Frames[0][0]
+		for i, frame := range p.Frames {
+			for ; next < frame[1]; next++ {
+				acc.Update(p.Rows[next])
+			}
+			result[i] = acc.Result()
+		}
+		return result
+	}
+	for i, frame := range p.Frames {
+		acc := agg.NewAccumulator()
+		for j := frame[0]; j < frame[1]; j++ {
+			acc.Update(p.Rows[j])
+		}
+		result[i] = acc.Result()
+	}
+	return result
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sql-engine/formatter"
+	"sql-engine/parser"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	prompt         = "sql-engine> "
+	continuePrompt = "         -> "
+	historyFile    = ".sql_engine_history"
+	historyLimit   = 1000
+)
+
+// 命令行交互, 语句以 ';' 结束, 可以跨越多行
+type shell struct {
+	session *parser.Session
+	out     io.Writer
+	errOut  io.Writer
+
+	history     []string
+	historyPath string
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // 正在执行的语句, 用于 ctrl-c 取消
+}
+
+func newShell(session *parser.Session, out, errOut io.Writer) *shell {
+	return &shell{
+		session: session,
+		out:     out,
+		errOut:  errOut,
+	}
+}
+
+// 依次执行脚本中的语句, 遇到错误时停止, 返回进程退出码
+func (s *shell) runScript(script string) int {
+	statements, rest := splitStatements(script)
+	// 最后一条语句可以省略 ';'
+	if !isBlank(rest) {
+		statements = append(statements, rest)
+	}
+	for _, sql := range statements {
+		if err := s.execute(context.Background(), sql, s.errOut); err != nil {
+			fmt.Fprintln(s.errOut, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func (s *shell) interactive(in io.Reader) {
+	s.loadHistory()
+	s.handleInterrupt()
+	fmt.Fprintln(s.out, "type 'help' for help, statements end with ';'")
+
+	scanner := bufio.NewScanner(in)
+	var buffer strings.Builder
+	fmt.Fprint(s.out, prompt)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if buffer.Len() == 0 && s.metaCommand(line) {
+			fmt.Fprint(s.out, prompt)
+			continue
+		}
+		buffer.WriteString(line)
+		buffer.WriteString("\n")
+		statements, rest := splitStatements(buffer.String())
+		for _, sql := range statements {
+			s.addHistory(sql)
+			s.executeInteractive(sql)
+		}
+		buffer.Reset()
+		if !isBlank(rest) {
+			buffer.WriteString(rest)
+			fmt.Fprint(s.out, continuePrompt)
+		} else {
+			fmt.Fprint(s.out, prompt)
+		}
+	}
+	fmt.Fprintln(s.out)
+}
+
+// 返回 true 表示已作为 shell 命令处理
+func (s *shell) metaCommand(line string) bool {
+	switch strings.ToLower(strings.TrimSuffix(strings.TrimSpace(line), ";")) {
+	case "":
+		return true
+	case "exit", "quit":
+		os.Exit(0)
+	case "help":
+		fmt.Fprintln(s.out, `statements:
+  select ... ;              run a query
+  set [key [= value]] ;     show or change a setting
+  reset [key] ;             restore settings
+  show settings ;           list all settings
+commands:
+  history                   show statement history
+  help                      show this message
+  exit, quit                leave the shell
+press ctrl-c to cancel a running query`)
+		return true
+	case "history":
+		for i, sql := range s.history {
+			fmt.Fprintf(s.out, "%5d  %s\n", i+1, sql)
+		}
+		return true
+	}
+	return false
+}
+
+func (s *shell) executeInteractive(sql string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.cancel = nil
+		s.mu.Unlock()
+		cancel()
+	}()
+	if err := s.execute(ctx, sql, s.out); err != nil {
+		fmt.Fprintln(s.errOut, err)
+	}
+}
+
+// 执行一条语句并输出结果, 耗时输出到 timing
+func (s *shell) execute(ctx context.Context, sql string, timing io.Writer) error {
+	start := time.Now()
+	dataset, err := s.session.Execute(ctx, sql)
+	if err != nil {
+		return err
+	}
+	if err = formatter.Format(s.out, s.session.Conf().OutputFormat, dataset); err != nil {
+		return err
+	}
+	unit := "rows"
+	if len(dataset.Data) == 1 {
+		unit = "row"
+	}
+	fmt.Fprintf(timing, "%d %s in set (%.3f sec)\n", len(dataset.Data), unit, time.Since(start).Seconds())
+	return nil
+}
+
+// ctrl-c 取消正在执行的语句, 没有语句执行时退出
+func (s *shell) handleInterrupt() {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		for range interrupt {
+			s.mu.Lock()
+			cancel := s.cancel
+			s.mu.Unlock()
+			if cancel == nil {
+				fmt.Fprintln(s.out)
+				os.Exit(130)
+			}
+			cancel()
+		}
+	}()
+}
+
+// 历史记录保存在用户目录下, 多行语句合并为一行
+func (s *shell) loadHistory() {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+	s.historyPath = filepath.Join(home, historyFile)
+	data, err := ioutil.ReadFile(s.historyPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			s.history = append(s.history, line)
+		}
+	}
+	if len(s.history) > historyLimit {
+		s.history = s.history[len(s.history)-historyLimit:]
+	}
+}
+
+func (s *shell) addHistory(sql string) {
+	line := strings.Join(strings.Fields(stripComments(sql)), " ") + ";"
+	s.history = append(s.history, line)
+	if s.historyPath == "" {
+		return
+	}
+	f, err := os.OpenFile(s.historyPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, _ = fmt.Fprintln(f, line)
+}
+
+// 按 ';' 拆分语句, 忽略字符串和注释中的 ';'. rest 为最后一个 ';' 之后还未结束的部分
+func splitStatements(text string) (statements []string, rest string) {
+	var quote rune
+	inComment := false
+	start := 0
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case inComment:
+			if c == '\n' {
+				inComment = false
+			}
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			inComment = true
+		case c == ';':
+			if sql := strings.TrimSpace(string(runes[start:i])); !isBlank(sql) {
+				statements = append(statements, sql)
+			}
+			start = i + 1
+		}
+	}
+	return statements, string(runes[start:])
+}
+
+// 去除 -- 注释, 保留字符串中的内容
+func stripComments(sql string) string {
+	var result strings.Builder
+	var quote rune
+	inComment := false
+	runes := []rune(sql)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case inComment:
+			if c != '\n' {
+				continue
+			}
+			inComment = false
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			inComment = true
+			continue
+		}
+		result.WriteRune(c)
+	}
+	return result.String()
+}
+
+// 只有空白和注释
+func isBlank(sql string) bool {
+	for _, line := range strings.Split(sql, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
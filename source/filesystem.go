@@ -1,15 +1,23 @@
 package source
 
 import (
-	"io/ioutil"
+	"context"
+	"io"
+	"os"
 	"sql-engine/config"
 	"sql-engine/expression"
 	"sql-engine/rows"
+	"sql-engine/sqlerr"
 	"sql-engine/util/pointer"
 )
 
+// 每次从目录中读取的文件数量
+const readDirBatch = 100
+
 type fileSystemSource struct {
-	path string
+	path   string
+	dir    *os.File
+	buffer []os.FileInfo
 }
 
 func newFilesystem(args []string, _ config.SQLConf) Source {
@@ -22,19 +30,41 @@ func (f *fileSystemSource) GetSchema() []rows.StructField {
 	return buildSchema(names, types)
 }
 
-func (f *fileSystemSource) Execute([]expression.Expression) [][]interface{} {
-	var result [][]interface{}
-	dir, err := ioutil.ReadDir(f.path)
+func (f *fileSystemSource) Open(context.Context, []expression.Expression) error {
+	dir, err := os.Open(f.path)
 	if err != nil {
-		panic(err)
+		return &sqlerr.SourceError{Input: f.path, Err: err}
+	}
+	f.dir = dir
+	f.buffer = nil
+	return nil
+}
+
+func (f *fileSystemSource) Next() ([]interface{}, error) {
+	if len(f.buffer) == 0 {
+		infos, err := f.dir.Readdir(readDirBatch)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, &sqlerr.SourceError{Input: f.path, Err: err}
+		}
+		f.buffer = infos
 	}
-	for _, info := range dir {
-		result = append(result, []interface{}{
-			pointer.String(info.Name()),
-			pointer.Int64(info.Size()),
-			pointer.Int64(info.ModTime().Unix()),
-			pointer.Bool(info.IsDir()),
-		})
+	info := f.buffer[0]
+	f.buffer = f.buffer[1:]
+	return []interface{}{
+		pointer.String(info.Name()),
+		pointer.Int64(info.Size()),
+		pointer.Int64(info.ModTime().Unix()),
+		pointer.Bool(info.IsDir()),
+	}, nil
+}
+
+func (f *fileSystemSource) Close() {
+	if f.dir != nil {
+		_ = f.dir.Close()
+		f.dir = nil
 	}
-	return result
+	f.buffer = nil
 }
@@ -3,24 +3,36 @@ package source
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"os/exec"
 	"regexp"
 	"sql-engine/config"
 	"sql-engine/expression"
 	"sql-engine/rows"
+	"sql-engine/sqlerr"
 	"sql-engine/util/pointer"
 	"strings"
 )
 
+var foundItemsRegex = regexp.MustCompile("Found \\d+ items")
+
 type hdfsSource struct {
+	bin  string
 	path string
 	du   bool
 	s    bool
+
+	ctx     context.Context
+	cmd     *exec.Cmd
+	stderr  *bytes.Buffer
+	scanner *bufio.Scanner
+	done    chan struct{}
 }
 
-func newHdfs(args []string, _ config.SQLConf) Source {
+func newHdfs(args []string, conf config.SQLConf) Source {
 	params := buildParams(args)
-	source := &hdfsSource{path: args[len(args)-1]}
+	source := &hdfsSource{bin: conf.HadoopBin, path: args[len(args)-1]}
 	if params["-du"] {
 		source.du = true
 		if params["-s"] {
@@ -40,50 +52,99 @@ func (h *hdfsSource) GetSchema() []rows.StructField {
 	return buildSchema(names, types)
 }
 
-func (h *hdfsSource) Execute([]expression.Expression) [][]interface{} {
-	var result [][]interface{}
-	if h.du {
-		var cmd *exec.Cmd
-		if h.s {
-			cmd = exec.Command("hadoop", "fs", "-du", "-s", h.path)
-		} else {
-			cmd = exec.Command("hadoop", "fs", "-du", h.path)
+// 启动 hadoop 命令, 从标准输出中逐行读取, ctx 取消时结束整个进程组,
+// 避免包装脚本启动的子进程继续占用输出, 使读取无法结束
+func (h *hdfsSource) Open(ctx context.Context, _ []expression.Expression) error {
+	args := []string{"fs", "-ls", h.path}
+	if h.du && h.s {
+		args = []string{"fs", "-du", "-s", h.path}
+	} else if h.du {
+		args = []string{"fs", "-du", h.path}
+	}
+	h.ctx = ctx
+	h.cmd = exec.CommandContext(ctx, h.bin, args...)
+	setProcessGroup(h.cmd)
+	h.stderr = &bytes.Buffer{}
+	h.cmd.Stderr = h.stderr
+	stdout, err := h.cmd.StdoutPipe()
+	if err != nil {
+		return cmdError(h.path, err, "")
+	}
+	if err = h.cmd.Start(); err != nil {
+		return cmdError(h.path, err, h.stderr.String())
+	}
+	h.done = make(chan struct{})
+	go func(cmd *exec.Cmd, done chan struct{}) {
+		select {
+		case <-ctx.Done():
+			killProcess(cmd)
+		case <-done:
 		}
-		output, err := cmd.Output()
-		if err != nil && strings.Contains(err.Error(), "No such file or directory") {
-			return result
+	}(h.cmd, h.done)
+	h.scanner = bufio.NewScanner(stdout)
+	return nil
+}
+
+// 等待命令结束并停止对 ctx 的监听
+func (h *hdfsSource) wait() error {
+	cmd := h.cmd
+	h.cmd = nil
+	err := cmd.Wait()
+	close(h.done)
+	return err
+}
+
+func (h *hdfsSource) Next() ([]interface{}, error) {
+	for h.scanner.Scan() {
+		split := strings.Fields(h.scanner.Text())
+		if len(split) == 0 {
+			continue
 		}
-		checkCmdError(err)
-		scanner := bufio.NewScanner(bytes.NewReader(output))
-		for scanner.Scan() {
-			split := strings.Fields(scanner.Text())
-			result = append(result, []interface{}{
+		if h.du {
+			if len(split) < 3 {
+				return nil, h.unexpected()
+			}
+			return []interface{}{
 				pointer.Int64From(split[0]),
 				pointer.String(split[2]),
-			})
+			}, nil
 		}
-		return result
-	}
-	cmd := exec.Command("hadoop", "fs", "-ls", h.path)
-	output, err := cmd.Output()
-	if err != nil && strings.Contains(err.Error(), "No such file or directory") {
-		return result
-	}
-	checkCmdError(err)
-	regex := regexp.MustCompile("Found \\d+ items")
-	scanner := bufio.NewScanner(bytes.NewReader(output))
-	for scanner.Scan() {
-		if regex.MatchString(scanner.Text()) {
+		if foundItemsRegex.MatchString(h.scanner.Text()) {
 			continue
 		}
-		split := strings.Fields(scanner.Text())
-		result = append(result, []interface{}{
+		if len(split) < 8 {
+			return nil, h.unexpected()
+		}
+		return []interface{}{
 			pointer.String(split[2]),
 			pointer.Int64From(split[4]),
 			pointer.String(split[5]),
 			pointer.String(split[6]),
 			pointer.String(split[7]),
-		})
+		}, nil
+	}
+	// 输出读取完毕，检查命令的执行结果，路径不存在时视为没有数据
+	if h.cmd != nil {
+		err := h.wait()
+		if ctxErr := h.ctx.Err(); ctxErr != nil {
+			return nil, &sqlerr.SourceError{Input: h.path, Err: ctxErr}
+		}
+		if err != nil && strings.Contains(h.stderr.String(), "No such file or directory") {
+			return nil, nil
+		}
+		return nil, cmdError(h.path, err, h.stderr.String())
+	}
+	return nil, nil
+}
+
+func (h *hdfsSource) unexpected() error {
+	return &sqlerr.SourceError{Input: h.path, Err: errors.New("unexpected output: " + h.scanner.Text())}
+}
+
+// 提前关闭时结束 hadoop 进程
+func (h *hdfsSource) Close() {
+	if h.cmd != nil && h.cmd.Process != nil {
+		killProcess(h.cmd)
+		_ = h.wait()
 	}
-	return result
 }
@@ -0,0 +1,23 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package source
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// 在新的进程组中启动命令, 结束时连同脚本启动的子进程一起结束
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcess(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		_ = cmd.Process.Kill()
+	}
+}
@@ -1,16 +1,23 @@
 package source
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os/exec"
 	"sql-engine/config"
 	"sql-engine/expression"
 	"sql-engine/rows"
+	"sql-engine/sqlerr"
 	"strings"
 )
 
+// 数据源以迭代器的方式逐行读取, ctx 取消后需要尽快结束读取
 type Source interface {
 	GetSchema() []rows.StructField
-	Execute(pushDownPredicate []expression.Expression) [][]interface{}
+	Open(ctx context.Context, pushDownPredicate []expression.Expression) error
+	Next() ([]interface{}, error) // 数据读完时返回 nil
+	Close()
 }
 
 var sourceFactory = map[string]func([]string, config.SQLConf) Source{
@@ -18,28 +25,35 @@ var sourceFactory = map[string]func([]string, config.SQLConf) Source{
 	"fs":   newFilesystem,
 }
 
-func NewSource(conf config.SQLConf, input string) Source {
+// hdfs:// 开头的路径使用 hdfs, 单个路径使用 fs, 否则第一个单词为数据源名称
+func NewSource(conf config.SQLConf, input string) (Source, error) {
+	conf = conf.WithDefaults()
+	name, args := "", strings.Fields(input)
 	if strings.HasPrefix(input, "hdfs://") {
-		return &hdfsSource{path: input}
+		name, args = "hdfs", []string{input}
+	} else if len(args) == 1 {
+		name, args = "fs", []string{input}
+	} else if len(args) > 1 {
+		name, args = args[0], args[1:]
 	}
-	inputs := strings.Fields(input)
-	if len(inputs) == 1 {
-		return &fileSystemSource{path: input}
+	factory, ok := sourceFactory[name]
+	if !ok {
+		return nil, &sqlerr.SourceError{Input: input, Err: errors.New("nonsupport data source")}
 	}
-	if source, ok := sourceFactory[inputs[0]]; ok {
-		return source(inputs[1:], conf)
+	if !conf.SourceAllowed(name) {
+		return nil, &sqlerr.SourceError{Input: input, Err: fmt.Errorf("data source '%s' is not allowed", name)}
 	}
-	panic("nonsupport data source: " + input)
+	return factory(args, conf), nil
 }
 
-func checkCmdError(err error) {
+func cmdError(input string, err error, stderr string) error {
 	if err == nil {
-		return
+		return nil
 	}
-	if e, ok := err.(*exec.ExitError); ok {
-		panic(err.Error() + ", " + string(e.Stderr))
+	if _, ok := err.(*exec.ExitError); ok {
+		return &sqlerr.SourceError{Input: input, Err: fmt.Errorf("%w, %s", err, stderr)}
 	}
-	panic(err)
+	return &sqlerr.SourceError{Input: input, Err: err}
 }
 
 func buildParams(args []string) map[string]bool {
@@ -0,0 +1,79 @@
+package sqlerr
+
+import (
+	"fmt"
+)
+
+// sql 语法错误, 行列从 1 开始
+type SyntaxError struct {
+	Row int
+	Col int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error: %s at (%d, %d)", e.Msg, e.Row, e.Col)
+}
+
+// 分析阶段的错误, 如字段不存在、类型不匹配
+type AnalysisError struct {
+	Msg string
+	Err error // 原始错误, 可为空
+}
+
+func (e *AnalysisError) Error() string {
+	return "analysis error: " + e.Msg
+}
+
+func (e *AnalysisError) Unwrap() error {
+	return e.Err
+}
+
+// 执行阶段的错误
+type ExecutionError struct {
+	Msg string
+	Err error // 原始错误, 可为空
+}
+
+func (e *ExecutionError) Error() string {
+	return "execution error: " + e.Msg
+}
+
+func (e *ExecutionError) Unwrap() error {
+	return e.Err
+}
+
+// 数据源读取错误
+type SourceError struct {
+	Input string
+	Err   error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("source error: '%s', %v", e.Input, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// 将 recover 得到的值转为 error, 已经是本包定义的错误时直接返回, 否则使用 wrap 转换
+func FromPanic(r interface{}, wrap func(msg string, err error) error) error {
+	err, ok := r.(error)
+	if !ok {
+		return wrap(fmt.Sprint(r), nil)
+	}
+	switch err.(type) {
+	case *SyntaxError, *AnalysisError, *ExecutionError, *SourceError:
+		return err
+	}
+	return wrap(err.Error(), err)
+}
+
+func NewAnalysisError(msg string, err error) error {
+	return &AnalysisError{Msg: msg, Err: err}
+}
+
+func NewExecutionError(msg string, err error) error {
+	return &ExecutionError{Msg: msg, Err: err}
+}